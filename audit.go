@@ -0,0 +1,423 @@
+package swagger
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Constants for the audit log of proxied requests
+const (
+	envVarProxyAuditSinks        = "PROXY_AUDIT_SINKS"         // Comma-separated sinks: stdout, file:<path>, http(s)://<webhook>
+	envVarProxyAuditRedactQuery  = "PROXY_AUDIT_REDACT_QUERY"  // Query parameters whose values are redacted; "*" for all
+	envVarProxyAuditRedactFields = "PROXY_AUDIT_REDACT_FIELDS" // JSON body fields whose values are redacted
+	envVarProxyAuditBodyBytes    = "PROXY_AUDIT_BODY_BYTES"    // Bodies up to this size are recorded; 0 records none
+
+	auditSinkStdout     = "stdout"
+	auditSinkFilePrefix = "file:"
+	auditQueueSize      = 1024 // Events waiting to be written; more are dropped rather than delaying requests
+	auditWebhookTimeout = 5 * time.Second
+	auditRedacted       = "REDACTED"
+
+	logMsgAuditSinkInvalid  = "Ignoring invalid %s entry %q: %v"
+	logMsgAuditDropped      = "Audit queue full; dropped event for %s %s"
+	logMsgAuditWriteFailed  = "Failed to write audit event to %s: %v"
+	logMsgAuditSinksEnabled = "Auditing proxied requests to %d sink(s)"
+)
+
+// defaultAuditRedactQuery and defaultAuditRedactFields name values that commonly carry secrets.
+var (
+	defaultAuditRedactQuery  = []string{"access_token", "api_key", "apikey", "code", "key", "password", "secret", "sig", "signature", "token"}
+	defaultAuditRedactFields = []string{"password", "secret", "token", "accessToken", "access_token", "refreshToken", "refresh_token", "clientSecret", "client_secret", "apiKey", "api_key"}
+)
+
+// auditEvent is one proxied call as recorded in the audit log.
+type auditEvent struct {
+	Time          time.Time       `json:"time"`
+	User          string          `json:"user,omitempty"` // From PROXY_CLIENT_IDENTITY_HEADER, if authenticated
+	ClientIP      string          `json:"clientIp"`
+	API           string          `json:"api,omitempty"`
+	Cluster       string          `json:"cluster,omitempty"`
+	Method        string          `json:"method"`
+	Target        string          `json:"target"`             // Upstream URL with redacted query parameters
+	Status        int             `json:"status,omitempty"`   // Omitted if the connection was hijacked without a response
+	Hijacked      bool            `json:"hijacked,omitempty"` // The connection was taken over: upgraded (e.g. WebSocket) or reset by fault injection
+	LatencyMillis int64           `json:"latencyMs"`
+	RequestBytes  int64           `json:"requestBytes"`
+	ResponseBytes int64           `json:"responseBytes"`
+	RequestBody   json.RawMessage `json:"requestBody,omitempty"`
+	ResponseBody  json.RawMessage `json:"responseBody,omitempty"`
+}
+
+// auditSink writes audit events somewhere.
+type auditSink interface {
+	write(event []byte) error
+	String() string
+}
+
+// auditLog records proxied calls to its sinks. Events are queued and written by a single
+// goroutine, so slow sinks don't delay requests.
+type auditLog struct {
+	redactor
+	sinks     []auditSink
+	bodyBytes int
+	queue     chan auditEvent
+
+	mux    sync.RWMutex  // Guards closed against sends on the closed queue
+	closed bool          // Set by close; later events are dropped
+	done   chan struct{} // Closed once the writer has written every queued event
+}
+
+// redactor removes sensitive values from URLs and JSON bodies before they are stored.
+type redactor struct {
+	redactQuery  []string // Query parameters whose values are redacted; "*" for all
+	redactFields []string // JSON fields whose values are redacted
+}
+
+// newRedactor returns the redaction rules configured by PROXY_AUDIT_REDACT_QUERY and
+// PROXY_AUDIT_REDACT_FIELDS, in addition to the defaults.
+func newRedactor() redactor {
+	return redactor{
+		redactQuery:  append(append([]string{}, defaultAuditRedactQuery...), getEnvList(envVarProxyAuditRedactQuery)...),
+		redactFields: append(append([]string{}, defaultAuditRedactFields...), getEnvList(envVarProxyAuditRedactFields)...),
+	}
+}
+
+// newAuditLog creates the audit log configured by the PROXY_AUDIT_* variables, or nil if no
+// sinks are configured.
+func (s *Server) newAuditLog() *auditLog {
+	var sinks []auditSink
+	for _, spec := range getEnvList(envVarProxyAuditSinks) {
+		sink, err := newAuditSink(spec)
+		if err != nil {
+			s.logger.Warnf(logMsgAuditSinkInvalid, envVarProxyAuditSinks, spec, err)
+			continue
+		}
+		sinks = append(sinks, sink)
+	}
+	if len(sinks) == 0 {
+		return nil
+	}
+
+	a := &auditLog{
+		redactor:  newRedactor(),
+		sinks:     sinks,
+		bodyBytes: s.getEnvInt(envVarProxyAuditBodyBytes, 0),
+		queue:     make(chan auditEvent, auditQueueSize),
+		done:      make(chan struct{}),
+	}
+	go s.writeAuditEvents(a)
+	s.logger.Infof(logMsgAuditSinksEnabled, len(sinks))
+	return a
+}
+
+// newAuditSink parses a sink specification.
+func newAuditSink(spec string) (auditSink, error) {
+	switch {
+	case spec == auditSinkStdout:
+		return &writerSink{name: auditSinkStdout, w: os.Stdout}, nil
+	case strings.HasPrefix(spec, auditSinkFilePrefix):
+		path := strings.TrimPrefix(spec, auditSinkFilePrefix)
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		return &writerSink{name: spec, w: file}, nil
+	case strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://"):
+		if _, err := url.Parse(spec); err != nil {
+			return nil, err
+		}
+		return &webhookSink{url: spec, client: &http.Client{Timeout: auditWebhookTimeout}}, nil
+	}
+	return nil, fmt.Errorf("expected %q, %q<path> or an http(s) URL", auditSinkStdout, auditSinkFilePrefix)
+}
+
+// writeAuditEvents writes queued events to every sink until the queue is closed.
+func (s *Server) writeAuditEvents(a *auditLog) {
+	defer close(a.done)
+	for event := range a.queue {
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false) // Keep URLs readable
+		if err := encoder.Encode(event); err != nil {
+			continue
+		}
+		line := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+		for _, sink := range a.sinks {
+			if err := sink.write(line); err != nil {
+				s.logger.Errorf(logMsgAuditWriteFailed, sink, err)
+			}
+		}
+	}
+}
+
+// writerSink writes events as JSON lines to a file or stdout.
+type writerSink struct {
+	name string
+	mux  sync.Mutex
+	w    io.Writer
+}
+
+func (s *writerSink) write(event []byte) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	_, err := s.w.Write(append(event, '\n'))
+	return err
+}
+
+func (s *writerSink) String() string { return s.name }
+
+// webhookSink POSTs each event as JSON to a URL.
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *webhookSink) write(event []byte) error {
+	resp, err := s.client.Post(s.url, contentTypeJSON, bytes.NewReader(event))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *webhookSink) String() string { return s.url }
+
+// auditRecorder captures what the audit log needs about one proxied call while it is served.
+type auditRecorder struct {
+	http.ResponseWriter
+	log     *auditLog
+	started time.Time
+	status  int
+	hijack  bool // The handler took over the connection before writing a status
+
+	requestBody   *captureReader
+	responseBytes int64
+	responseBody  bytes.Buffer
+
+	target  *proxyTarget // Set once the target is resolved
+	request *http.Request
+}
+
+// beginAudit starts recording a proxied call. It returns the response writer and request to
+// serve the call with, and the recorder to pass to finishAudit; all are unchanged if auditing
+// is disabled.
+func (s *Server) beginAudit(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request, *auditRecorder) {
+	if s.audit == nil {
+		return w, r, nil
+	}
+	rec := &auditRecorder{ResponseWriter: w, log: s.audit, started: time.Now(), request: r}
+	if r.Body != nil && r.Body != http.NoBody {
+		rec.requestBody = &captureReader{ReadCloser: r.Body, limit: s.audit.bodyBytes}
+		r.Body = rec.requestBody
+	}
+	return rec, r, rec
+}
+
+// finishAudit queues the audit event of a proxied call.
+func (s *Server) finishAudit(rec *auditRecorder) {
+	if rec == nil {
+		return
+	}
+	r := rec.request
+	event := auditEvent{
+		Time:          rec.started.UTC(),
+		ClientIP:      clientIP(r),
+		Method:        r.Method,
+		Status:        rec.status,
+		LatencyMillis: time.Since(rec.started).Milliseconds(),
+		ResponseBytes: rec.responseBytes,
+	}
+	if s.identityHeader != "" {
+		event.User = r.Header.Get(s.identityHeader)
+	}
+	if event.Status == 0 {
+		if rec.hijack {
+			event.Hijacked = true
+		} else {
+			event.Status = http.StatusOK // Nothing was written
+		}
+	}
+	target := r.URL
+	if rec.target != nil {
+		event.API = rec.target.apiKey
+		event.Cluster = rec.target.cluster
+		target = rec.target.url
+	}
+	event.Target = rec.log.redactURL(target)
+	if rec.requestBody != nil {
+		event.RequestBytes = rec.requestBody.n
+		event.RequestBody = rec.log.redactBody(r.Header.Get(headerContentType), rec.requestBody.buf.Bytes(), rec.requestBody.n)
+	}
+	event.ResponseBody = rec.log.redactBody(rec.Header().Get(headerContentType), rec.responseBody.Bytes(), rec.responseBytes)
+
+	rec.log.mux.RLock()
+	defer rec.log.mux.RUnlock()
+	if rec.log.closed {
+		return
+	}
+	select {
+	case rec.log.queue <- event:
+	default:
+		s.logger.Warnf(logMsgAuditDropped, event.Method, event.Target)
+	}
+}
+
+// close stops accepting events and waits until the queued ones are written, or ctx is done.
+func (a *auditLog) close(ctx context.Context) error {
+	a.mux.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+	a.mux.Unlock()
+
+	select {
+	case <-a.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (rec *auditRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter.
+func (rec *auditRecorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(p)
+	rec.responseBytes += int64(n)
+	if remaining := rec.log.bodyBytes - rec.responseBody.Len(); remaining > 0 {
+		rec.responseBody.Write(p[:min(n, remaining)])
+	}
+	return n, err
+}
+
+// Hijack implements http.Hijacker, so that calls whose connection is taken over (upgrades and
+// fault-injected resets) are recorded as hijacked rather than as 200. A failed hijack is followed
+// by an error response or an aborted handler, so it counts as well unless a status is written.
+func (rec *auditRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if rec.status == 0 {
+		rec.hijack = true
+	}
+	return http.NewResponseController(rec.ResponseWriter).Hijack()
+}
+
+// Unwrap returns the underlying response writer, so that http.ResponseController can flush
+// streamed responses and hijack upgraded connections.
+func (rec *auditRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// captureReader counts the bytes read from a request body and keeps the first limit of them.
+type captureReader struct {
+	io.ReadCloser
+	limit int
+	n     int64
+	buf   bytes.Buffer
+}
+
+// Read implements io.Reader.
+func (c *captureReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	if remaining := c.limit - c.buf.Len(); remaining > 0 {
+		c.buf.Write(p[:min(n, remaining)])
+	}
+	return n, err
+}
+
+// redactURL returns target with the values of sensitive query parameters replaced.
+func (a redactor) redactURL(target *url.URL) string {
+	redacted := *target
+	redacted.User = nil
+	query := redacted.Query()
+	for name := range query {
+		if a.redactAll() || matchFold(a.redactQuery, name) {
+			query[name] = []string{auditRedacted}
+		}
+	}
+	if len(query) > 0 {
+		redacted.RawQuery = query.Encode()
+	}
+	return redacted.String()
+}
+
+// redactAll reports whether every query parameter is redacted.
+func (a redactor) redactAll() bool {
+	return matchFold(a.redactQuery, "*")
+}
+
+// redactBody returns a captured body for the audit log: complete JSON bodies with sensitive
+// fields replaced. Other bodies (non-JSON, or larger than PROXY_AUDIT_BODY_BYTES) are left
+// out, since they can't be redacted reliably.
+func (a *auditLog) redactBody(contentType string, body []byte, size int64) json.RawMessage {
+	if a.bodyBytes <= 0 || len(body) == 0 || int64(len(body)) < size {
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType != contentTypeJSON && !strings.HasSuffix(mediaType, "+json") {
+		return nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return nil
+	}
+	redacted, err := json.Marshal(a.redactValue(value))
+	if err != nil {
+		return nil
+	}
+	return redacted
+}
+
+// redactValue replaces the values of sensitive fields anywhere in a decoded JSON value.
+func (a redactor) redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for field, fieldValue := range v {
+			if matchFold(a.redactFields, field) {
+				v[field] = auditRedacted
+			} else {
+				v[field] = a.redactValue(fieldValue)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = a.redactValue(item)
+		}
+	}
+	return value
+}
+
+// matchFold reports whether name is one of names, ignoring case.
+func matchFold(names []string, name string) bool {
+	for _, candidate := range names {
+		if strings.EqualFold(candidate, name) {
+			return true
+		}
+	}
+	return false
+}
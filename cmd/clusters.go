@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	server "openapi-multi-swagger"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// cluster is a connection to one Kubernetes cluster that APIs are discovered in.
+type cluster struct {
+	name       string                             // Cluster name used to tag APIMetadata; empty in single-cluster mode
+	loadConfig func() (*rest.Config, bool, error) // Resolves the REST config and whether it is the in-cluster config
+	config     *rest.Config                       // Set on first successful connection
+	clientset  kubernetes.Interface               // Set on first successful connection
+	specs      []server.APIMetadata               // Specs last loaded from this cluster
+}
+
+// newClusters builds the cluster connections from the configuration.
+// Without KUBE_CONTEXTS a single unnamed cluster is used (in-cluster config, falling back to
+// the local kubeconfig). With KUBE_CONTEXTS one cluster is created per kubeconfig context,
+// plus the in-cluster connection if IN_CLUSTER_NAME is set.
+func newClusters() []*cluster {
+	if len(kubeContexts) == 0 {
+		return []*cluster{{name: inClusterName, loadConfig: getKubeConfig}}
+	}
+
+	var clusters []*cluster
+	if inClusterName != "" {
+		clusters = append(clusters, &cluster{name: inClusterName, loadConfig: getInClusterConfig})
+	}
+	for _, contextName := range kubeContexts {
+		contextName := contextName
+		clusters = append(clusters, &cluster{
+			name: contextName,
+			loadConfig: func() (*rest.Config, bool, error) {
+				return getContextConfig(contextName)
+			},
+		})
+	}
+	return clusters
+}
+
+// describe returns a log suffix identifying the cluster, or an empty string in single-cluster mode.
+func (c *cluster) describe() string {
+	if c.name == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (cluster '%s')", c.name)
+}
+
+// connect returns the cluster's clientset, creating it on first use. Clusters reached from
+// outside get an API server service proxy registered on the server, so that their Service URLs
+// are fetched through the right cluster connection.
+func (c *cluster) connect(s *server.Server) (kubernetes.Interface, error) {
+	if c.clientset != nil {
+		return c.clientset, nil
+	}
+
+	config, inCluster, err := c.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	// Remote clusters are always reached through their API server. In single-cluster mode this is
+	// opt-in via SERVICE_PROXY, since the local network may already route Service URLs.
+	if !inCluster && (len(kubeContexts) > 0 || serviceProxy) {
+		proxy, err := server.NewServiceProxy(config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create API server service proxy: %w", err)
+		}
+		s.SetServiceProxy(c.name, proxy)
+		logger.Infof("Routing in-cluster Service URLs%s through the API server at %s", c.describe(), config.Host)
+	} else if inCluster && serviceProxy {
+		logger.Info("Running in-cluster; SERVICE_PROXY is not needed and will be ignored.")
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client set: %w", err)
+	}
+	s.SetSecretReader(c.name, secretReader(clientset))
+	c.config = config
+	c.clientset = clientset
+	return clientset, nil
+}
+
+// loadSpecs connects to the cluster if needed, loads the API specs from its ConfigMap
+// and tags each of them with the cluster name.
+func (c *cluster) loadSpecs(ctx context.Context, s *server.Server, configMapNamespace string, cmName string) ([]server.APIMetadata, error) {
+	clientset, err := c.connect(s)
+	if err != nil {
+		return nil, err
+	}
+	specs, err := loadSpecs(ctx, clientset, configMapNamespace, cmName)
+	if err != nil {
+		return nil, err
+	}
+	for i := range specs {
+		specs[i].Cluster = c.name
+	}
+
+	if kubernetesAPIs {
+		kubeSpecs, err := c.loadKubernetesAPIs(ctx)
+		if err != nil {
+			// The Kubernetes APIs are an addition to the ConfigMap entries, so don't fail the cluster
+			logger.Errorf("Failed to load Kubernetes APIs%s: %v", c.describe(), err)
+		} else {
+			specs = append(specs, kubeSpecs...)
+		}
+	}
+	return specs, nil
+}
+
+// secretReader returns a SecretReader that reads Secrets through the given clientset,
+// for credentials the proxy injects into requests to the cluster's APIs.
+func secretReader(clientset kubernetes.Interface) server.SecretReader {
+	return func(ctx context.Context, namespace, name, key string) (string, error) {
+		secret, err := clientset.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		value, ok := secret.Data[key]
+		if !ok {
+			return "", fmt.Errorf("key %q not found", key)
+		}
+		return string(value), nil
+	}
+}
+
+// getInClusterConfig returns the in-cluster configuration of the pod's own cluster.
+func getInClusterConfig() (*rest.Config, bool, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get in-cluster config: %w", err)
+	}
+	return config, true, nil
+}
+
+// getContextConfig returns the configuration of a named context from the kubeconfig
+// (KUBECONFIG or ~/.kube/config).
+func getContextConfig(contextName string) (*rest.Config, bool, error) {
+	loader := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(),
+		&clientcmd.ConfigOverrides{CurrentContext: contextName},
+	)
+	config, err := loader.ClientConfig()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to load kubeconfig context '%s': %w", contextName, err)
+	}
+	logger.Infof("Successfully loaded kubeconfig context '%s'", contextName)
+	return config, false, nil
+}
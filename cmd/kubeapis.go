@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	server "openapi-multi-swagger"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/openapi"
+)
+
+// Constants for the built-in Kubernetes API source
+const (
+	kubeAPIsNamespace        = "kubernetes"       // Pseudo-namespace grouping the cluster's API groups in the UI
+	kubeCRDsNamespace        = "custom-resources" // Pseudo-namespace grouping CustomResourceDefinitions in the UI
+	kubeAPIResourceType      = "APIGroupVersion"
+	kubeCRDResourceType      = "CustomResourceDefinition"
+	kubeAPINamePrefix        = "kubernetes-"
+	kubeCRDNamePrefix        = "crd-"
+	kubeOpenAPIV3Path        = "/openapi/v3/"
+	kubeOpenAPIContentType   = "application/json"
+	kubeCoreGroupPath        = "api/"
+	kubeNamedGroupPathPrefix = "apis/"
+)
+
+// crdResource is the CustomResourceDefinition resource, listed through the dynamic client so
+// that no apiextensions client is needed.
+var crdResource = schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+
+// loadKubernetesAPIs returns one APIMetadata per API group version published by the cluster's
+// API server at /openapi/v3, and one per installed CustomResourceDefinition with a document
+// generated from its structural schemas. Groups that belong to CRDs are only listed as CRDs.
+// The documents are read-only: no methods are allowed for "Try it out".
+func (c *cluster) loadKubernetesAPIs(ctx context.Context) ([]server.APIMetadata, error) {
+	dynamicClient, err := dynamic.NewForConfig(c.config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+	crds, err := dynamicClient.Resource(crdResource).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list CustomResourceDefinitions: %w", err)
+	}
+
+	var specs []server.APIMetadata
+	crdGroups := make(map[string]bool)
+	for i := range crds.Items {
+		crd := crds.Items[i]
+		group, _, _ := unstructured.NestedString(crd.Object, "spec", "group")
+		crdGroups[group] = true
+		specs = append(specs, server.APIMetadata{
+			Name:         kubeCRDNamePrefix + crd.GetName(),
+			URL:          strings.TrimSuffix(c.config.Host, "/") + "/apis/" + group,
+			ResourceType: kubeCRDResourceType,
+			ResourceName: crd.GetName(),
+			Namespace:    kubeCRDsNamespace,
+			LastUpdated:  crd.GetCreationTimestamp().Format("2006-01-02T15:04:05Z07:00"),
+			Cluster:      c.name,
+			Fetch: func() (map[string]interface{}, error) {
+				return crdOpenAPISpec(&crd), nil
+			},
+		})
+	}
+
+	paths, err := c.clientset.Discovery().OpenAPIV3().Paths()
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover OpenAPI v3 paths: %w", err)
+	}
+	for path, groupVersion := range paths {
+		name, ok := kubeAPIName(path, crdGroups)
+		if !ok {
+			continue
+		}
+		specs = append(specs, server.APIMetadata{
+			Name:         name,
+			URL:          strings.TrimSuffix(c.config.Host, "/") + kubeOpenAPIV3Path + path,
+			ResourceType: kubeAPIResourceType,
+			ResourceName: path,
+			Namespace:    kubeAPIsNamespace,
+			Cluster:      c.name,
+			Fetch:        groupVersionFetcher(groupVersion),
+		})
+	}
+
+	logger.Infof("Loaded %d Kubernetes API group(s) and CustomResourceDefinition(s)%s", len(specs), c.describe())
+	return specs, nil
+}
+
+// kubeAPIName returns the portal name for an /openapi/v3 path such as "api/v1" or "apis/apps/v1".
+// Paths that are not API group versions, or belong to a CRD group, are skipped.
+func kubeAPIName(path string, crdGroups map[string]bool) (string, bool) {
+	if strings.HasPrefix(path, kubeCoreGroupPath) {
+		return kubeAPINamePrefix + "core-" + strings.TrimPrefix(path, kubeCoreGroupPath), true
+	}
+	if !strings.HasPrefix(path, kubeNamedGroupPathPrefix) {
+		return "", false
+	}
+	groupVersion := strings.TrimPrefix(path, kubeNamedGroupPathPrefix)
+	group, _, _ := strings.Cut(groupVersion, "/")
+	if crdGroups[group] {
+		return "", false
+	}
+	return kubeAPINamePrefix + strings.ReplaceAll(groupVersion, "/", "-"), true
+}
+
+// groupVersionFetcher returns a SpecFetcher that downloads a group version's OpenAPI v3 document
+// from the API server, authenticated with the cluster's credentials.
+func groupVersionFetcher(groupVersion openapi.GroupVersion) server.SpecFetcher {
+	return func() (map[string]interface{}, error) {
+		data, err := groupVersion.Schema(kubeOpenAPIContentType)
+		if err != nil {
+			return nil, err
+		}
+		var spec map[string]interface{}
+		if err := json.Unmarshal(data, &spec); err != nil {
+			return nil, err
+		}
+		return spec, nil
+	}
+}
+
+// crdOpenAPISpec generates an OpenAPI 3.0 document for a CustomResourceDefinition, with
+// list/create/get/replace/delete operations and the structural schema of every served version.
+func crdOpenAPISpec(crd *unstructured.Unstructured) map[string]interface{} {
+	group, _, _ := unstructured.NestedString(crd.Object, "spec", "group")
+	kind, _, _ := unstructured.NestedString(crd.Object, "spec", "names", "kind")
+	plural, _, _ := unstructured.NestedString(crd.Object, "spec", "names", "plural")
+	scope, _, _ := unstructured.NestedString(crd.Object, "spec", "scope")
+	versions, _, _ := unstructured.NestedSlice(crd.Object, "spec", "versions")
+
+	paths := make(map[string]interface{})
+	schemas := make(map[string]interface{})
+	infoVersion := ""
+	for _, v := range versions {
+		version, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if served, _ := version["served"].(bool); !served {
+			continue
+		}
+		name, _ := version["name"].(string)
+		if storage, _ := version["storage"].(bool); storage || infoVersion == "" {
+			infoVersion = name
+		}
+
+		schemaName := fmt.Sprintf("%s.%s.%s", group, name, kind)
+		listSchemaName := schemaName + "List"
+		objectSchema, _, _ := unstructured.NestedMap(version, "schema", "openAPIV3Schema")
+		if objectSchema == nil {
+			objectSchema = map[string]interface{}{"type": "object", "x-kubernetes-preserve-unknown-fields": true}
+		}
+		schemas[schemaName] = objectSchema
+		schemas[listSchemaName] = map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"apiVersion": map[string]interface{}{"type": "string"},
+				"kind":       map[string]interface{}{"type": "string"},
+				"metadata":   map[string]interface{}{"type": "object"},
+				"items": map[string]interface{}{
+					"type":  "array",
+					"items": schemaRef(schemaName),
+				},
+			},
+		}
+
+		collectionPath := fmt.Sprintf("/apis/%s/%s/%s", group, name, plural)
+		var params []interface{}
+		if scope == "Namespaced" {
+			collectionPath = fmt.Sprintf("/apis/%s/%s/namespaces/{namespace}/%s", group, name, plural)
+			params = append(params, pathParameter("namespace"))
+		}
+		itemParams := append(append([]interface{}{}, params...), pathParameter("name"))
+		tags := []interface{}{fmt.Sprintf("%s/%s", group, name)}
+
+		paths[collectionPath] = map[string]interface{}{
+			"parameters": params,
+			"get":        crdOperation(tags, "list"+kind, "List "+kind+" objects", nil, listSchemaName),
+			"post":       crdOperation(tags, "create"+kind, "Create a "+kind, schemaRef(schemaName), schemaName),
+		}
+		paths[collectionPath+"/{name}"] = map[string]interface{}{
+			"parameters": itemParams,
+			"get":        crdOperation(tags, "read"+kind, "Read the specified "+kind, nil, schemaName),
+			"put":        crdOperation(tags, "replace"+kind, "Replace the specified "+kind, schemaRef(schemaName), schemaName),
+			"delete":     crdOperation(tags, "delete"+kind, "Delete the specified "+kind, nil, ""),
+		}
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.0",
+		"info": map[string]interface{}{
+			"title":       fmt.Sprintf("%s (%s)", kind, group),
+			"version":     infoVersion,
+			"description": fmt.Sprintf("Generated from the structural schemas of CustomResourceDefinition %s.", crd.GetName()),
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": schemas},
+	}
+}
+
+// crdOperation builds an OpenAPI operation with an optional JSON request body and response schema.
+func crdOperation(tags []interface{}, operationID, summary string, requestSchema map[string]interface{}, responseSchemaName string) map[string]interface{} {
+	response := map[string]interface{}{"description": "OK"}
+	if responseSchemaName != "" {
+		response["content"] = jsonContent(schemaRef(responseSchemaName))
+	}
+	operation := map[string]interface{}{
+		"tags":        tags,
+		"operationId": operationID,
+		"summary":     summary,
+		"responses":   map[string]interface{}{"200": response},
+	}
+	if requestSchema != nil {
+		operation["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  jsonContent(requestSchema),
+		}
+	}
+	return operation
+}
+
+// pathParameter builds a required string path parameter.
+func pathParameter(name string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":     name,
+		"in":       "path",
+		"required": true,
+		"schema":   map[string]interface{}{"type": "string"},
+	}
+}
+
+// schemaRef builds a reference to a schema in components.schemas.
+func schemaRef(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+// jsonContent builds an application/json content map for a schema.
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		kubeOpenAPIContentType: map[string]interface{}{"schema": schema},
+	}
+}
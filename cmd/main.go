@@ -3,9 +3,14 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv" // Added for parsing integer environment variables
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	server "openapi-multi-swagger" // Local module's swagger package (server.go in the root)
@@ -26,14 +31,22 @@ const (
 	envVarLogLevel        = "LOG_LEVEL" // Added for logrus configuration
 	envVarDevMode         = "DEV_MODE"  // Added for logrus configuration
 	devModeTrue           = "true"      // Added for logrus configuration
+	envVarServiceProxy    = "SERVICE_PROXY"
+	envVarKubeContexts    = "KUBE_CONTEXTS"   // Comma-separated kubeconfig contexts for multi-cluster discovery
+	envVarInClusterName   = "IN_CLUSTER_NAME" // Cluster name for the in-cluster connection in multi-cluster mode
+	envVarKubernetesAPIs  = "KUBERNETES_APIS" // Expose the cluster's API groups and CRDs as APIs
 )
 
 var (
-	namespace     string
-	configMapName string
-	port          int
-	watchInterval time.Duration
-	logger        *logrus.Logger // Global logger instance
+	namespace      string
+	configMapName  string
+	port           int
+	watchInterval  time.Duration
+	serviceProxy   bool           // Route in-cluster Service URLs through the API server when running outside the cluster
+	kubeContexts   []string       // Kubeconfig contexts to discover APIs in, one cluster per context
+	inClusterName  string         // Name of the in-cluster connection; empty to leave it out in multi-cluster mode
+	kubernetesAPIs bool           // Whether to list the Kubernetes API groups and CRDs of every cluster
+	logger         *logrus.Logger // Global logger instance
 )
 
 func initLogger() {
@@ -63,12 +76,20 @@ func loadConfig() {
 	configMapName = getStringEnv("CONFIGMAP_NAME", configMapNameValue)
 	port = getIntEnv("PORT", defaultPortValue)
 	watchInterval = getDurationEnv("WATCH_INTERVAL_SECONDS", watchIntervalValue)
+	serviceProxy = getStringEnv(envVarServiceProxy, "false") == "true"
+	kubeContexts = getListEnv(envVarKubeContexts)
+	inClusterName = getStringEnv(envVarInClusterName, "")
+	kubernetesAPIs = getStringEnv(envVarKubernetesAPIs, "false") == "true"
 
 	logger.Info("Configuration loaded:")
 	logger.Infof("  NAMESPACE: %s (Default: %s)", namespace, defaultNamespaceValue)
 	logger.Infof("  CONFIGMAP_NAME: %s (Default: %s)", configMapName, configMapNameValue)
 	logger.Infof("  PORT: %d (Default: %d)", port, defaultPortValue)
 	logger.Infof("  WATCH_INTERVAL: %s (Default: %s)", watchInterval, watchIntervalValue)
+	logger.Infof("  SERVICE_PROXY: %t (Default: false)", serviceProxy)
+	logger.Infof("  KUBE_CONTEXTS: %v (Default: [])", kubeContexts)
+	logger.Infof("  IN_CLUSTER_NAME: %s (Default: )", inClusterName)
+	logger.Infof("  KUBERNETES_APIS: %t (Default: false)", kubernetesAPIs)
 }
 
 // getStringEnv gets a string environment variable or returns a default value.
@@ -81,6 +102,17 @@ func getStringEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getListEnv gets a comma-separated environment variable as a list of trimmed, non-empty values.
+func getListEnv(key string) []string {
+	var values []string
+	for _, value := range strings.Split(getStringEnv(key, ""), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
 // getIntEnv gets an integer environment variable or returns a default value.
 func getIntEnv(key string, defaultValue int) int {
 	if valueStr, exists := os.LookupEnv(key); exists {
@@ -120,83 +152,117 @@ func main() {
 
 	logger.Infof("Using NAMESPACE: %s", namespace)
 
+	clusters := newClusters()
+
+	// SIGTERM (sent by Kubernetes when the pod is stopped) or Ctrl+C starts a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
 	// Start a goroutine to watch for ConfigMap changes and update API specs periodically
-	go watchSpecsConfigMap(s)
+	var workers sync.WaitGroup
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		watchSpecsConfigMap(ctx, s, clusters)
+	}()
 
 	logger.Infof("Swagger UI server starting on port %d", port)
-	// Start the server
-	if err := s.Start(port); err != nil {
+	// Run the server until a signal is received, then drain in-flight requests
+	err := s.Run(ctx, port)
+	stop() // Stop discovery as well if the server failed
+	workers.Wait()
+	if err != nil {
 		logger.Fatalf("Failed to start server: %v", err)
 	}
 }
 
-// watchSpecsConfigMap periodically checks the ConfigMap in the specified namespace,
+// watchSpecsConfigMap periodically checks the ConfigMap in the specified namespace of every cluster,
 // and if changes are detected, updates the API specifications and reflects them on the server.
-func watchSpecsConfigMap(s *server.Server) {
+// If a cluster cannot be reached, the specs last loaded from it are kept. It returns when ctx is done.
+func watchSpecsConfigMap(ctx context.Context, s *server.Server, clusters []*cluster) {
 	for {
-		logger.Infof("Attempting to load API specs from ConfigMap '%s' in namespace '%s'", configMapName, namespace)
-		// Load API specs from ConfigMap
-		specs := loadSpecs(namespace, configMapName) // Pass configMapName
+		var specs []server.APIMetadata
+		results := make(map[string]error, len(clusters))
+		for _, c := range clusters {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Infof("Attempting to load API specs from ConfigMap '%s' in namespace '%s'%s", configMapName, namespace, c.describe())
+			// Load API specs from ConfigMap
+			clusterSpecs, err := c.loadSpecs(ctx, s, namespace, configMapName) // Pass configMapName
+			results[c.name] = err
+			if err != nil {
+				logger.Errorf("Failed to load API specs%s: %v. Keeping %d previously loaded spec(s).", c.describe(), err, len(c.specs))
+			} else {
+				c.specs = clusterSpecs
+			}
+			specs = append(specs, c.specs...)
+		}
+		if ctx.Err() != nil {
+			return // Loads interrupted by the shutdown are incomplete
+		}
 		if len(specs) > 0 {
 			logger.Infof("Successfully loaded %d API spec(s). Updating server...", len(specs))
-			s.UpdateSpecs(specs) // Update the server with the loaded specs
+			s.UpdateSpecs(ctx, specs) // Update the server with the loaded specs
 		} else {
 			logger.Warn("No API specs loaded or an error occurred. Server not updated.")
 		}
+		s.ReportDiscovery(results) // Readiness reflects whether the clusters could be reached
+
 		// Wait for the next check
-		time.Sleep(watchInterval)
+		select {
+		case <-ctx.Done():
+			logger.Info("Stopped watching for API spec changes")
+			return
+		case <-time.After(watchInterval):
+		}
 	}
 }
 
-// loadSpecs loads the ConfigMap (by default "openapi-specs") from the specified namespace
-// using either in-cluster Kubernetes configuration or local kubeconfig,
-// parses the data within, and returns a list of API specifications.
-func loadSpecs(configMapNamespace string, cmName string) []server.APIMetadata {
-	var specs []server.APIMetadata
-
+// getKubeConfig returns the in-cluster Kubernetes configuration if running inside a cluster,
+// otherwise the configuration from the local kubeconfig. The boolean reports whether the
+// in-cluster configuration was used.
+func getKubeConfig() (*rest.Config, bool, error) {
 	// Try to use in-cluster config if running inside a Kubernetes cluster
 	config, err := rest.InClusterConfig()
-	if err != nil {
-		// If in-cluster config fails, try to use local kubeconfig
-		logger.Warnf("Failed to get in-cluster config: %v. Attempting to use local kubeconfig.", err)
-		home, homeErr := os.UserHomeDir()
-		if homeErr != nil {
-			logger.Errorf("Failed to get user home directory: %v", homeErr)
-			return specs // Return empty list if home directory cannot be found
-		}
-		kubeconfigPath := filepath.Join(home, ".kube", "config")
-		// Build config from local kubeconfig file
-		localConfig, localErr := clientcmd.BuildConfigFromFlags("", kubeconfigPath)
-		if localErr != nil {
-			logger.Errorf("Failed to load local kubeconfig at %s: %v", kubeconfigPath, localErr)
-			return specs // Return empty list if local kubeconfig fails to load
-		}
-		config = localConfig // Use local config
-		logger.Infof("Successfully loaded local kubeconfig from %s", kubeconfigPath)
-	} else {
+	if err == nil {
 		logger.Info("Successfully loaded in-cluster config.")
+		return config, true, nil
 	}
 
-	// Create Kubernetes clientset
-	clientset, err := kubernetes.NewForConfig(config)
+	// If in-cluster config fails, try to use local kubeconfig
+	logger.Warnf("Failed to get in-cluster config: %v. Attempting to use local kubeconfig.", err)
+	home, err := os.UserHomeDir()
 	if err != nil {
-		logger.Errorf("Failed to create Kubernetes client set: %v", err)
-		return specs // Return empty list if clientset creation fails
+		return nil, false, fmt.Errorf("failed to get user home directory: %w", err)
 	}
+	kubeconfigPath := filepath.Join(home, ".kube", "config")
+	// Build config from local kubeconfig file
+	config, err = clientcmd.BuildConfigFromFlags("", kubeconfigPath)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to load local kubeconfig at %s: %w", kubeconfigPath, err)
+	}
+	logger.Infof("Successfully loaded local kubeconfig from %s", kubeconfigPath)
+	return config, false, nil
+}
+
+// loadSpecs loads the ConfigMap (by default "openapi-specs") from the specified namespace
+// using the given clientset, parses the data within, and returns a list of API specifications.
+func loadSpecs(ctx context.Context, clientset kubernetes.Interface, configMapNamespace string, cmName string) ([]server.APIMetadata, error) {
+	var specs []server.APIMetadata
 
 	logger.Infof("Attempting to load ConfigMap '%s' from namespace '%s'", cmName, configMapNamespace)
 	// Get the ConfigMap
-	cm, err := clientset.CoreV1().ConfigMaps(configMapNamespace).Get(context.Background(), cmName, metav1.GetOptions{})
+	cm, err := clientset.CoreV1().ConfigMaps(configMapNamespace).Get(ctx, cmName, metav1.GetOptions{})
 	if err != nil {
-		logger.Errorf("Failed to get ConfigMap '%s' in namespace '%s': %v", cmName, configMapNamespace, err)
-		return specs // Return empty list if ConfigMap retrieval fails
+		return nil, fmt.Errorf("failed to get ConfigMap '%s' in namespace '%s': %w", cmName, configMapNamespace, err)
 	}
 
 	logger.Infof("Successfully loaded ConfigMap '%s'", cmName)
 
 	if len(cm.Data) == 0 {
 		logger.Warnf("No data found in ConfigMap '%s'", cmName)
-		return specs // Return empty list if no data in ConfigMap
+		return specs, nil // Return empty list if no data in ConfigMap
 	}
 
 	// Parse each data entry in the ConfigMap into API specifications
@@ -217,5 +283,5 @@ func loadSpecs(configMapNamespace string, cmName string) []server.APIMetadata {
 	} else {
 		logger.Infof("Loaded %d API specification(s) from ConfigMap '%s'", len(specs), cmName)
 	}
-	return specs
+	return specs, nil
 }
@@ -0,0 +1,354 @@
+package swagger
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Constants for checking backend responses against the documented responses of their operation
+const (
+	envVarProxyCheckContract        = "PROXY_CHECK_CONTRACT"          // Check proxied responses against the target API's spec
+	envVarProxyContractMaxBodyBytes = "PROXY_CONTRACT_MAX_BODY_BYTES" // Larger response bodies are not checked
+
+	defaultContractMaxBodyBytes = 1 << 20 // 1 MiB
+	httpPathContractStats       = "/contract-stats"
+	headerContractCheck         = "X-Contract-Check" // Result of the check, added to proxied responses
+	headerContentEncoding       = "Content-Encoding"
+
+	contractResultPass         = "pass"
+	contractResultFail         = "fail"
+	contractResultUndocumented = "undocumented" // The request matched no operation of the spec
+
+	logMsgContractViolated     = "Response of %s to %s %s (%s) violates its contract with status %d: %s"
+	logMsgContractPassed       = "Response of %s to %s %s conforms to its contract with status %d"
+	logMsgContractUndocumented = "Response of %s to %s %s can't be checked: the operation is not in the spec"
+	logMsgContractSkipped      = "Not checking the contract of %s: %v"
+	logMsgContractBodySkipped  = "Not checking the body of %s %s: unsupported Content-Encoding %q or body too large"
+	errMsgStatusNotDocumented  = "%d is not documented"
+	errMsgResponseBodyMissing  = "response body is missing"
+	errMsgContractStatsUnknown = "No contract statistics for API %s"
+)
+
+// contractCheck is the operation a proxied response is checked against.
+type contractCheck struct {
+	api   string
+	spec  map[string]interface{}
+	match *operationMatch // nil if the request matched no operation
+}
+
+// contractFailure describes the most recent response of an API that violated its contract.
+type contractFailure struct {
+	Time       time.Time         `json:"time"`
+	Method     string            `json:"method"`
+	Path       string            `json:"path"` // Path template of the operation
+	Status     int               `json:"status"`
+	Violations []schemaViolation `json:"violations"`
+}
+
+// operationContractStats counts the checked responses of one operation.
+type operationContractStats struct {
+	Checked int64 `json:"checked"`
+	Failed  int64 `json:"failed"`
+}
+
+// apiContractStats are the conformance statistics of one API, served at /contract-stats.
+type apiContractStats struct {
+	Checked      int64                              `json:"checked"`
+	Passed       int64                              `json:"passed"`
+	Failed       int64                              `json:"failed"`
+	Undocumented int64                              `json:"undocumented"`
+	Conformance  float64                            `json:"conformance"` // Share of checked responses that passed
+	Operations   map[string]*operationContractStats `json:"operations"`  // By "METHOD /path/{template}"
+	LastFailure  *contractFailure                   `json:"lastFailure,omitempty"`
+}
+
+// contractStats accumulates conformance statistics by API key.
+type contractStats struct {
+	mux  sync.Mutex
+	apis map[string]*apiContractStats
+}
+
+// shouldCheckContract reports whether proxied responses of an API are checked:
+// its checkContract setting if present, otherwise PROXY_CHECK_CONTRACT.
+func (s *Server) shouldCheckContract(key string) bool {
+	s.specsMux.RLock()
+	defer s.specsMux.RUnlock()
+	if override := s.specs[key].CheckContract; override != nil {
+		return *override
+	}
+	return s.checkContract
+}
+
+// prepareContractCheck looks up the operation the response of a proxied request will be checked
+// against. It returns nil if contract checking is disabled for the API or its spec is unavailable.
+func (s *Server) prepareContractCheck(r *http.Request, target *proxyTarget) *contractCheck {
+	if !s.shouldCheckContract(target.apiKey) {
+		return nil
+	}
+	spec, err := s.cachedSpecFor(target.apiKey)
+	if err != nil {
+		s.logger.Warnf(logMsgContractSkipped, target.apiKey, err)
+		return nil
+	}
+	match, _ := findOperation(spec, r.Method, target.url)
+	return &contractCheck{api: target.apiKey, spec: spec, match: match}
+}
+
+// checkResponseContract validates a backend response against the documented responses of its
+// operation: the status code, documented headers and, for JSON, the body. The body is buffered
+// up to PROXY_CONTRACT_MAX_BODY_BYTES and replaced, so it is still sent to the client. The result
+// is added as the X-Contract-Check header, logged and counted.
+func (s *Server) checkResponseContract(resp *http.Response, check *contractCheck, checkBody bool) error {
+	method, target := resp.Request.Method, resp.Request.URL.Redacted()
+	if check.match == nil {
+		resp.Header.Set(headerContractCheck, contractResultUndocumented)
+		s.logger.Debugf(logMsgContractUndocumented, check.api, method, target)
+		s.recordContractResult(check, resp.StatusCode, nil)
+		return nil
+	}
+
+	violations, err := s.responseViolations(resp, check, checkBody)
+	if err != nil {
+		return err
+	}
+	s.recordContractResult(check, resp.StatusCode, violations)
+	if len(violations) == 0 {
+		resp.Header.Set(headerContractCheck, contractResultPass)
+		s.logger.Debugf(logMsgContractPassed, check.api, method, target, resp.StatusCode)
+		return nil
+	}
+
+	resp.Header.Set(headerContractCheck, fmt.Sprintf("%s; violations=%d", contractResultFail, len(violations)))
+	descriptions := make([]string, len(violations))
+	for i, violation := range violations {
+		descriptions[i] = violation.Location + " " + violation.Message
+	}
+	s.logger.Warnf(logMsgContractViolated, check.api, method, target, check.match.Path, resp.StatusCode, strings.Join(descriptions, "; "))
+	return nil
+}
+
+// responseViolations returns the ways a response differs from its documented response.
+func (s *Server) responseViolations(resp *http.Response, check *contractCheck, checkBody bool) ([]schemaViolation, error) {
+	validator := &schemaValidator{spec: check.spec, direction: schemaResponse}
+	documented := documentedResponse(check.spec, check.match.Operation, resp.StatusCode)
+	if documented == nil {
+		return []schemaViolation{{Location: "status", Message: fmt.Sprintf(errMsgStatusNotDocumented, resp.StatusCode)}}, nil
+	}
+
+	var violations []schemaViolation
+	headers := asObject(documented["headers"])
+	for _, name := range sortedKeys(headers) {
+		if strings.EqualFold(name, headerContentType) {
+			continue // Described by the response content instead
+		}
+		definition := resolveRef(check.spec, asObject(headers[name]))
+		values := resp.Header.Values(name)
+		location := "header." + name
+		if len(values) == 0 {
+			if required, _ := definition["required"].(bool); required {
+				violations = append(violations, schemaViolation{Location: location, Message: errMsgParameterRequired})
+			}
+			continue
+		}
+		schema, ok := definition["schema"].(map[string]interface{})
+		if !ok {
+			schema = definition // Swagger 2.0 headers are schemas themselves
+		}
+		schema = resolveRef(check.spec, schema)
+		param := requestParameter{definition: definition, name: name, in: "header"}
+		violations = append(violations, validator.validate(schema, validator.parameterValue(param, schema, values), location)...)
+	}
+
+	if !checkBody || resp.Request.Method == http.MethodHead || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return violations, nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get(headerContentType))
+	var schema map[string]interface{}
+	if content := asObject(documented["content"]); len(content) > 0 {
+		if resp.ContentLength == 0 {
+			return append(violations, schemaViolation{Location: "body", Message: errMsgResponseBodyMissing}), nil
+		}
+		media, ok := selectMediaType(content, mediaType)
+		if !ok {
+			return append(violations, schemaViolation{
+				Location: "header." + headerContentType,
+				Message:  fmt.Sprintf(errMsgContentTypeNotAllowed, strings.Join(sortedKeys(content), ", ")),
+			}), nil
+		}
+		if isJSONMediaType(mediaType) {
+			schema = asObject(asObject(media)["schema"])
+		}
+	} else if mediaType == "" || isJSONMediaType(mediaType) {
+		schema = asObject(documented["schema"]) // Swagger 2.0
+	}
+	if schema == nil {
+		return violations, nil
+	}
+
+	body, err := s.bufferResponseBody(resp)
+	if err != nil || body == nil {
+		return violations, err
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return append(violations, schemaViolation{Location: "body", Message: errMsgResponseBodyMissing}), nil
+	}
+	body, ok := decodeBody(resp.Header.Get(headerContentEncoding), body, s.contractMaxBody)
+	if !ok {
+		s.logger.Debugf(logMsgContractBodySkipped, resp.Request.Method, resp.Request.URL.Redacted(), resp.Header.Get(headerContentEncoding))
+		return violations, nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return append(violations, schemaViolation{Location: "body", Message: fmt.Sprintf(errMsgBodyInvalidJSON, err)}), nil
+	}
+	return append(violations, validator.validate(schema, value, "body")...), nil
+}
+
+// documentedResponse returns the response object of an operation that applies to a status code:
+// the exact code, then its range (e.g. "4XX"), then "default". It returns nil if there is none.
+func documentedResponse(spec, operation map[string]interface{}, status int) map[string]interface{} {
+	responses := asObject(operation["responses"])
+	code := strconv.Itoa(status)
+	for _, key := range []string{code, code[:1] + "XX", code[:1] + "xx", "default"} {
+		if response, ok := responses[key].(map[string]interface{}); ok {
+			return resolveRef(spec, response)
+		}
+	}
+	return nil
+}
+
+// bufferResponseBody reads a response body into memory and replaces it with the buffered copy.
+// It returns nil if the body is larger than PROXY_CONTRACT_MAX_BODY_BYTES; the body is then
+// passed on unchecked.
+func (s *Server) bufferResponseBody(resp *http.Response) ([]byte, error) {
+	limit := s.contractMaxBody
+	if resp.ContentLength > limit {
+		return nil, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		resp.Body = readCloser{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return nil, nil
+	}
+	resp.Body = readCloser{bytes.NewReader(body), resp.Body}
+	return body, nil
+}
+
+// decodeBody returns a response body decoded according to its Content-Encoding (gzip or deflate)
+// for inspection; the response itself is passed on as received. It returns false if the encoding is not supported or the
+// decoded body is larger than limit.
+func decodeBody(encoding string, body []byte, limit int64) ([]byte, bool) {
+	var reader io.Reader
+	var err error
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return body, true
+	case "gzip", "x-gzip":
+		reader, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		// "deflate" is meant to be zlib-wrapped, but some servers send raw deflate data
+		if reader, err = zlib.NewReader(bytes.NewReader(body)); err != nil {
+			reader, err = flate.NewReader(bytes.NewReader(body)), nil
+		}
+	default:
+		return nil, false
+	}
+	if err != nil {
+		return nil, false
+	}
+	decoded, err := io.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil || int64(len(decoded)) > limit {
+		return nil, false
+	}
+	return decoded, true
+}
+
+// recordContractResult adds the result of a check to the statistics and metrics of its API.
+func (s *Server) recordContractResult(check *contractCheck, status int, violations []schemaViolation) {
+	result := contractResultPass
+	switch {
+	case check.match == nil:
+		result = contractResultUndocumented
+	case len(violations) > 0:
+		result = contractResultFail
+	}
+	s.metrics.contractChecks.inc(check.api, result)
+
+	s.contracts.mux.Lock()
+	defer s.contracts.mux.Unlock()
+	stats, ok := s.contracts.apis[check.api]
+	if !ok {
+		stats = &apiContractStats{Operations: make(map[string]*operationContractStats)}
+		s.contracts.apis[check.api] = stats
+	}
+	if check.match == nil {
+		stats.Undocumented++
+		return
+	}
+
+	stats.Checked++
+	operationKey := check.match.Method + " " + check.match.Path
+	operation, ok := stats.Operations[operationKey]
+	if !ok {
+		operation = &operationContractStats{}
+		stats.Operations[operationKey] = operation
+	}
+	operation.Checked++
+	if len(violations) == 0 {
+		stats.Passed++
+	} else {
+		stats.Failed++
+		operation.Failed++
+		stats.LastFailure = &contractFailure{
+			Time:       time.Now().UTC(),
+			Method:     check.match.Method,
+			Path:       check.match.Path,
+			Status:     status,
+			Violations: violations,
+		}
+	}
+	stats.Conformance = float64(stats.Passed) / float64(stats.Checked)
+}
+
+// forgetContractStats drops the statistics of APIs that are no longer registered.
+func (c *contractStats) forgetContractStats(specs map[string]APIMetadata) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for key := range c.apis {
+		if _, ok := specs[key]; !ok {
+			delete(c.apis, key)
+		}
+	}
+}
+
+// serveContractStats serves the conformance statistics of all APIs, or of the API named by the
+// "api" query parameter.
+func (s *Server) serveContractStats(w http.ResponseWriter, r *http.Request) {
+	s.contracts.mux.Lock()
+	defer s.contracts.mux.Unlock()
+
+	if api := r.URL.Query().Get("api"); api != "" {
+		stats, ok := s.contracts.apis[api]
+		if !ok {
+			s.logAndSendError(w, r, http.StatusNotFound, fmt.Sprintf(errMsgContractStatsUnknown, api), fmt.Sprintf(errMsgContractStatsUnknown, api))
+			return
+		}
+		s.writeJSON(w, r, http.StatusOK, stats)
+		return
+	}
+	s.writeJSON(w, r, http.StatusOK, s.contracts.apis)
+}
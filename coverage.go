@@ -0,0 +1,256 @@
+package swagger
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Constants for the operation coverage report
+const (
+	envVarProxyCoverage     = "PROXY_COVERAGE" // Counts proxied calls by operation for /coverage
+	httpPathCoverage        = "/coverage"
+	coverageMaxUndocumented = 100 // Distinct undocumented calls tracked per API
+
+	logMsgCoverageSpecFailed = "Failed to load spec of %s for its coverage report: %v"
+	errMsgCoverageUnknown    = "No coverage report for API %s"
+	errMsgCoverageDisabled   = "Coverage tracking is disabled; set " + envVarProxyCoverage + "=true to enable it"
+)
+
+// specMethods are the operation keys of a path item.
+var specMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// callCount counts the proxied calls of an operation or undocumented path.
+type callCount struct {
+	calls      int64
+	lastCalled time.Time
+}
+
+// apiTraffic is the proxied traffic of one API: calls by documented operation
+// ("METHOD /path/{template}") and by undocumented method and request path.
+type apiTraffic struct {
+	operations   map[string]*callCount
+	undocumented map[string]*undocumentedCall
+}
+
+// coverageStats accumulates proxied traffic by API key.
+type coverageStats struct {
+	mux  sync.Mutex
+	apis map[string]*apiTraffic
+}
+
+// operationCoverage is a documented operation and how often it was called.
+type operationCoverage struct {
+	Method      string     `json:"method"`
+	Path        string     `json:"path"` // Path template
+	OperationID string     `json:"operationId,omitempty"`
+	Calls       int64      `json:"calls"`
+	LastCalled  *time.Time `json:"lastCalled,omitempty"`
+}
+
+// undocumentedCall is a request path called through the proxy that matches no operation.
+type undocumentedCall struct {
+	Method         string    `json:"method"`
+	Path           string    `json:"path"`           // Upstream request path
+	PathDocumented bool      `json:"pathDocumented"` // The path exists, but not with this method
+	Calls          int64     `json:"calls"`
+	LastCalled     time.Time `json:"lastCalled"`
+}
+
+// apiCoverage is the coverage report of one API, served at /coverage.
+type apiCoverage struct {
+	Operations         int                 `json:"operations"` // Documented operations
+	Called             int                 `json:"called"`     // Documented operations called at least once
+	Coverage           float64             `json:"coverage"`   // Share of documented operations called
+	CalledOperations   []operationCoverage `json:"calledOperations"`
+	UncalledOperations []operationCoverage `json:"uncalledOperations"`
+	Undocumented       []undocumentedCall  `json:"undocumented"`
+	Error              string              `json:"error,omitempty"` // Why the spec couldn't be loaded
+}
+
+// trackCoverage counts a proxied call to a registered API, if PROXY_COVERAGE is enabled. The
+// call is matched against the API's spec in the background, so that fetching the spec doesn't
+// delay the request.
+func (s *Server) trackCoverage(r *http.Request, target *proxyTarget) {
+	if !s.coverageEnabled || target.class != proxyTargetRegistered {
+		return
+	}
+	targetURL := *target.url // The request may still change it
+	go s.recordCoverage(r.Method, target.apiKey, &targetURL, time.Now().UTC())
+}
+
+// hasFetchedSpec reports whether an API's document comes from a Fetch function, as with the
+// Kubernetes APIs. Those APIs are read-only, so they get no proxied traffic to report on.
+func (s *Server) hasFetchedSpec(key string) bool {
+	s.specsMux.RLock()
+	defer s.specsMux.RUnlock()
+	return s.specs[key].Fetch != nil
+}
+
+// recordCoverage counts a proxied call to a registered API against the operation it matches,
+// or as an undocumented call if it matches none.
+func (s *Server) recordCoverage(method, apiKey string, targetURL *url.URL, now time.Time) {
+	if s.hasFetchedSpec(apiKey) {
+		return
+	}
+	spec, err := s.cachedSpecFor(apiKey)
+	if err != nil {
+		return
+	}
+	match, pathFound := findOperation(spec, method, targetURL)
+
+	s.coverage.mux.Lock()
+	defer s.coverage.mux.Unlock()
+	traffic, ok := s.coverage.apis[apiKey]
+	if !ok {
+		traffic = &apiTraffic{operations: make(map[string]*callCount), undocumented: make(map[string]*undocumentedCall)}
+		s.coverage.apis[apiKey] = traffic
+	}
+
+	if match != nil {
+		operationKey := match.Method + " " + match.Path
+		count, ok := traffic.operations[operationKey]
+		if !ok {
+			count = &callCount{}
+			traffic.operations[operationKey] = count
+		}
+		count.calls++
+		if now.After(count.lastCalled) {
+			count.lastCalled = now
+		}
+		return
+	}
+
+	callKey := method + " " + targetURL.Path
+	call, ok := traffic.undocumented[callKey]
+	if !ok {
+		if len(traffic.undocumented) >= coverageMaxUndocumented {
+			return
+		}
+		call = &undocumentedCall{Method: method, Path: targetURL.Path, PathDocumented: pathFound}
+		traffic.undocumented[callKey] = call
+	}
+	call.Calls++
+	if now.After(call.LastCalled) {
+		call.LastCalled = now
+	}
+}
+
+// forgetCoverage drops the traffic of APIs that are no longer registered.
+func (c *coverageStats) forgetCoverage(specs map[string]APIMetadata) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for key := range c.apis {
+		if _, ok := specs[key]; !ok {
+			delete(c.apis, key)
+		}
+	}
+}
+
+// apiCoverageReport compares the recorded traffic of an API with the operations of its spec.
+func (s *Server) apiCoverageReport(key string) *apiCoverage {
+	report := &apiCoverage{
+		CalledOperations:   []operationCoverage{},
+		UncalledOperations: []operationCoverage{},
+		Undocumented:       []undocumentedCall{},
+	}
+
+	s.coverage.mux.Lock()
+	operations := make(map[string]callCount)
+	if traffic, ok := s.coverage.apis[key]; ok {
+		for operationKey, count := range traffic.operations {
+			operations[operationKey] = *count
+		}
+		for _, call := range traffic.undocumented {
+			report.Undocumented = append(report.Undocumented, *call)
+		}
+	}
+	s.coverage.mux.Unlock()
+	sort.Slice(report.Undocumented, func(i, j int) bool {
+		a, b := report.Undocumented[i], report.Undocumented[j]
+		return a.Path < b.Path || a.Path == b.Path && a.Method < b.Method
+	})
+
+	spec, err := s.cachedSpecFor(key)
+	if err != nil {
+		s.logger.Warnf(logMsgCoverageSpecFailed, key, err)
+		report.Error = err.Error()
+		return report
+	}
+	paths := asObject(spec["paths"])
+	for _, path := range sortedKeys(paths) {
+		pathItem := asObject(paths[path])
+		for _, method := range specMethods {
+			operation, ok := pathItem[method].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			entry := operationCoverage{Method: strings.ToUpper(method), Path: path}
+			entry.OperationID, _ = operation["operationId"].(string)
+			report.Operations++
+			count, called := operations[entry.Method+" "+path]
+			if !called {
+				report.UncalledOperations = append(report.UncalledOperations, entry)
+				continue
+			}
+			entry.Calls = count.calls
+			lastCalled := count.lastCalled
+			entry.LastCalled = &lastCalled
+			report.CalledOperations = append(report.CalledOperations, entry)
+			report.Called++
+		}
+	}
+	if report.Operations > 0 {
+		report.Coverage = float64(report.Called) / float64(report.Operations)
+	}
+	return report
+}
+
+// serveCoverage serves the coverage reports of the registered APIs that were called through the
+// proxy, or of the API named by the "api" query parameter.
+func (s *Server) serveCoverage(w http.ResponseWriter, r *http.Request) {
+	if !s.coverageEnabled {
+		s.logAndSendError(w, r, http.StatusNotFound, errMsgCoverageDisabled, errMsgCoverageDisabled)
+		return
+	}
+
+	// Reporting on an API fetches its spec, so only APIs with traffic (whose spec is usually
+	// cached) are listed by default
+	s.coverage.mux.Lock()
+	called := make(map[string]bool, len(s.coverage.apis))
+	for key := range s.coverage.apis {
+		called[key] = true
+	}
+	s.coverage.mux.Unlock()
+	s.specsMux.RLock()
+	var keys, reported []string
+	for key, api := range s.specs {
+		if api.Fetch != nil {
+			continue
+		}
+		keys = append(keys, key)
+		if called[key] {
+			reported = append(reported, key)
+		}
+	}
+	s.specsMux.RUnlock()
+
+	if api := r.URL.Query().Get("api"); api != "" {
+		if !containsString(keys, api) {
+			s.logAndSendError(w, r, http.StatusNotFound, fmt.Sprintf(errMsgCoverageUnknown, api), fmt.Sprintf(errMsgCoverageUnknown, api))
+			return
+		}
+		s.writeJSON(w, r, http.StatusOK, s.apiCoverageReport(api))
+		return
+	}
+
+	reports := make(map[string]*apiCoverage, len(reported))
+	for _, key := range reported {
+		reports[key] = s.apiCoverageReport(key)
+	}
+	s.writeJSON(w, r, http.StatusOK, reports)
+}
@@ -0,0 +1,254 @@
+package swagger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// Constants for proxy credential injection
+const (
+	credentialSecretTTL = 30 * time.Second // How long a Secret value is reused before it is read again
+
+	credentialSourceStatic = "static"
+	credentialSourceSecret = "secret"
+	credentialSourceOAuth2 = "oauth2"
+
+	headerAuthorization = "Authorization"
+
+	logMsgCredentialsFailed     = "Failed to obtain credentials for API %s: %v"
+	logMsgCredentialsRotated    = "Credentials of API %s changed; discarding cached token"
+	errMsgCredentialsFailed     = "Failed to obtain credentials for the upstream API"
+	errMsgNoSecretReader        = "no Secret reader is configured for cluster %q"
+	errMsgCredentialsAmbiguous  = "exactly one of value, secretRef and oauth2 must be set"
+	errMsgOAuth2MissingTokenURL = "oauth2.tokenUrl is required"
+)
+
+// APICredentials configures a credential that the proxy attaches to requests to an API, so that
+// "Try it out" works against services that require authentication without the browser ever
+// seeing the credential. Exactly one of Value, SecretRef and OAuth2 must be set.
+type APICredentials struct {
+	Header    string        `json:"header,omitempty"`    // Header to set; defaults to Authorization
+	Prefix    string        `json:"prefix,omitempty"`    // Prepended to a SecretRef value, e.g. "Bearer "
+	Value     string        `json:"value,omitempty"`     // Static header value
+	SecretRef *SecretKeyRef `json:"secretRef,omitempty"` // Header value read from a Kubernetes Secret
+	OAuth2    *OAuth2Config `json:"oauth2,omitempty"`    // Bearer token from an OAuth2 client-credentials grant
+}
+
+// SecretKeyRef selects a key of a Kubernetes Secret.
+type SecretKeyRef struct {
+	Namespace string `json:"namespace,omitempty"` // Defaults to the API's namespace
+	Name      string `json:"name"`
+	Key       string `json:"key"`
+}
+
+// OAuth2Config configures an OAuth2 client-credentials grant. The client secret is read from a
+// Kubernetes Secret; tokens are cached per API until they expire.
+type OAuth2Config struct {
+	TokenURL        string            `json:"tokenUrl"`
+	ClientID        string            `json:"clientId"`
+	ClientSecretRef *SecretKeyRef     `json:"clientSecretRef,omitempty"`
+	Scopes          []string          `json:"scopes,omitempty"`
+	EndpointParams  map[string]string `json:"endpointParams,omitempty"` // Extra token request parameters, e.g. audience
+}
+
+// SecretReader returns the value of a key of a Kubernetes Secret.
+type SecretReader func(ctx context.Context, namespace, name, key string) (string, error)
+
+// MarshalJSON describes the credential without revealing it, so that APIMetadata can be
+// served to the browser as is.
+func (c APICredentials) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Header string `json:"header"`
+		Source string `json:"source"`
+	}{Header: c.headerName(), Source: c.source()})
+}
+
+// headerName returns the header the credential is sent in.
+func (c APICredentials) headerName() string {
+	if c.Header == "" {
+		return headerAuthorization
+	}
+	return http.CanonicalHeaderKey(c.Header)
+}
+
+// source returns where the credential comes from, or "" if it is misconfigured.
+func (c APICredentials) source() string {
+	switch {
+	case c.Value != "" && c.SecretRef == nil && c.OAuth2 == nil:
+		return credentialSourceStatic
+	case c.Value == "" && c.SecretRef != nil && c.OAuth2 == nil:
+		return credentialSourceSecret
+	case c.Value == "" && c.SecretRef == nil && c.OAuth2 != nil:
+		return credentialSourceOAuth2
+	}
+	return ""
+}
+
+// cachedSecretValue is a Secret value and the time it was read.
+type cachedSecretValue struct {
+	value   string
+	fetched time.Time
+}
+
+// cachedTokenSource is the token source of an API and the configuration it was built from.
+type cachedTokenSource struct {
+	fingerprint string // Changes when the client configuration or secret changes
+	source      oauth2.TokenSource
+}
+
+// credentialStore resolves API credentials and caches Secret values and OAuth2 tokens.
+type credentialStore struct {
+	mux     sync.Mutex
+	readers map[string]SecretReader      // Secret readers by cluster name
+	secrets map[string]cachedSecretValue // Secret values by cluster/namespace/name/key
+	tokens  map[string]cachedTokenSource // OAuth2 token sources by API key
+}
+
+// SetSecretReader configures how Secrets referenced by credentials of APIs in the named cluster
+// are read. The empty cluster name applies to APIs without a cluster.
+func (s *Server) SetSecretReader(cluster string, reader SecretReader) {
+	s.credentials.mux.Lock()
+	defer s.credentials.mux.Unlock()
+	s.credentials.readers[cluster] = reader
+}
+
+// credentialHeader returns the header that carries the credentials of a registered API, or nil
+// if the API has none.
+func (s *Server) credentialHeader(ctx context.Context, key string) (http.Header, error) {
+	s.specsMux.RLock()
+	metadata, exists := s.specs[key]
+	s.specsMux.RUnlock()
+	if !exists || metadata.Credentials == nil {
+		return nil, nil
+	}
+	creds := metadata.Credentials
+
+	var value string
+	switch creds.source() {
+	case credentialSourceStatic:
+		value = creds.Value
+	case credentialSourceSecret:
+		secret, err := s.readSecret(ctx, metadata, creds.SecretRef)
+		if err != nil {
+			return nil, err
+		}
+		value = creds.Prefix + secret
+	case credentialSourceOAuth2:
+		token, err := s.oauth2Token(ctx, key, metadata)
+		if err != nil {
+			return nil, err
+		}
+		value = token.Type() + " " + token.AccessToken
+	default:
+		return nil, errors.New(errMsgCredentialsAmbiguous)
+	}
+	return http.Header{creds.headerName(): []string{value}}, nil
+}
+
+// readSecret returns a Secret value, reading it again once it is older than credentialSecretTTL
+// so that rotated Secrets are picked up.
+func (s *Server) readSecret(ctx context.Context, api APIMetadata, ref *SecretKeyRef) (string, error) {
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = api.Namespace
+	}
+	cacheKey := strings.Join([]string{api.Cluster, namespace, ref.Name, ref.Key}, "/")
+
+	s.credentials.mux.Lock()
+	cached, ok := s.credentials.secrets[cacheKey]
+	reader := s.credentials.readers[api.Cluster]
+	s.credentials.mux.Unlock()
+	if ok && time.Since(cached.fetched) < credentialSecretTTL {
+		return cached.value, nil
+	}
+	if reader == nil {
+		return "", fmt.Errorf(errMsgNoSecretReader, api.Cluster)
+	}
+
+	value, err := reader(ctx, namespace, ref.Name, ref.Key)
+	if err != nil {
+		return "", fmt.Errorf("failed to read Secret %s/%s key %q: %w", namespace, ref.Name, ref.Key, err)
+	}
+	s.credentials.mux.Lock()
+	defer s.credentials.mux.Unlock()
+	s.credentials.secrets[cacheKey] = cachedSecretValue{value: value, fetched: time.Now()}
+	return value, nil
+}
+
+// oauth2Token returns a valid client-credentials token of an API. Tokens are reused until they
+// expire; the token source is rebuilt when the client configuration or secret changes.
+func (s *Server) oauth2Token(ctx context.Context, key string, api APIMetadata) (*oauth2.Token, error) {
+	config := api.Credentials.OAuth2
+	if config.TokenURL == "" {
+		return nil, errors.New(errMsgOAuth2MissingTokenURL)
+	}
+	var clientSecret string
+	if config.ClientSecretRef != nil {
+		var err error
+		if clientSecret, err = s.readSecret(ctx, api, config.ClientSecretRef); err != nil {
+			return nil, err
+		}
+	}
+
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint := string(configJSON) + "\x00" + clientSecret
+
+	s.credentials.mux.Lock()
+	cached, ok := s.credentials.tokens[key]
+	if !ok || cached.fingerprint != fingerprint {
+		if ok {
+			s.logger.Infof(logMsgCredentialsRotated, key)
+		}
+		cached = cachedTokenSource{fingerprint: fingerprint, source: s.newTokenSource(api, clientSecret)}
+		s.credentials.tokens[key] = cached
+	}
+	s.credentials.mux.Unlock()
+
+	return cached.source.Token()
+}
+
+// newTokenSource creates a caching client-credentials token source. The token endpoint is
+// reached like spec URLs, so in-cluster token services work through the service proxy too.
+func (s *Server) newTokenSource(api APIMetadata, clientSecret string) oauth2.TokenSource {
+	config := api.Credentials.OAuth2
+	tokenURL, client := s.resolveUpstream(api.Cluster, config.TokenURL, &http.Client{Timeout: discoveryTimeout})
+
+	params := make(map[string][]string, len(config.EndpointParams))
+	for name, value := range config.EndpointParams {
+		params[name] = []string{value}
+	}
+	grant := &clientcredentials.Config{
+		ClientID:       config.ClientID,
+		ClientSecret:   clientSecret,
+		TokenURL:       tokenURL,
+		Scopes:         config.Scopes,
+		EndpointParams: params,
+	}
+	// The token source outlives the request that created it, so it must not use its context
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, client)
+	return grant.TokenSource(ctx)
+}
+
+// forgetCredentials drops cached tokens of APIs that are gone or whose credentials changed
+// source, so that stale tokens are never sent.
+func (c *credentialStore) forgetCredentials(apis map[string]APIMetadata) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for key := range c.tokens {
+		if api, exists := apis[key]; !exists || api.Credentials == nil || api.Credentials.OAuth2 == nil {
+			delete(c.tokens, key)
+		}
+	}
+}
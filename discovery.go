@@ -0,0 +1,79 @@
+package swagger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// discoveryTimeout bounds a single request made while resolving discovery options.
+const discoveryTimeout = 5 * time.Second
+
+// expandSpecs resolves discovery options of API metadata before the specs are stored:
+//   - APIs with Environments are replaced by one entry per environment.
+//   - APIs with AutoProbe set get their URL replaced with the first well-known spec location
+//     that serves a valid document.
+//   - APIs with a SwaggerConfigURL are replaced by one child API per group listed in it.
+//
+// APIs whose discovery fails carry an Error and are skipped by UpdateSpecs.
+// APIs are processed concurrently; the order of the input is preserved. Requests are canceled
+// when ctx is done.
+func (s *Server) expandSpecs(ctx context.Context, apis []APIMetadata) []APIMetadata {
+	apis = s.expandEnvironments(apis)
+	results := make([][]APIMetadata, len(apis))
+	var wg sync.WaitGroup
+	for i, api := range apis {
+		results[i] = []APIMetadata{api}
+		if (!api.AutoProbe && api.SwaggerConfigURL == "") || api.Error != "" {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, api APIMetadata) {
+			defer wg.Done()
+			if api.AutoProbe {
+				s.probeSpec(ctx, &api)
+			}
+			if api.SwaggerConfigURL != "" && api.Error == "" {
+				results[i] = s.expandSwaggerConfig(ctx, api)
+				return
+			}
+			results[i] = []APIMetadata{api}
+		}(i, api)
+	}
+	wg.Wait()
+
+	var expanded []APIMetadata
+	for _, result := range results {
+		expanded = append(expanded, result...)
+	}
+	return expanded
+}
+
+// fetchJSON fetches rawURL in the given cluster and decodes the JSON response into v.
+func (s *Server) fetchJSON(ctx context.Context, cluster string, rawURL string, v interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, discoveryTimeout)
+	defer cancel()
+
+	urlStr, client := s.resolveUpstream(cluster, rawURL, http.DefaultClient)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, urlStr, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", contentTypeJSON)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			s.logger.Warnf(logErrFailedToCloseResponseBody, closeErr)
+		}
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
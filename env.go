@@ -0,0 +1,46 @@
+package swagger
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// getEnvList returns a comma-separated environment variable as a list of trimmed, non-empty values.
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getEnvInt returns an integer environment variable, or defaultValue if it is unset or invalid.
+func (s *Server) getEnvInt(key string, defaultValue int) int {
+	valueStr, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	value, err := strconv.Atoi(strings.TrimSpace(valueStr))
+	if err != nil {
+		s.logger.Warnf(logMsgInvalidEnvInt, key, valueStr, defaultValue)
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvFloat returns a floating-point environment variable, or defaultValue if it is unset or invalid.
+func (s *Server) getEnvFloat(key string, defaultValue float64) float64 {
+	valueStr, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(valueStr), 64)
+	if err != nil {
+		s.logger.Warnf(logMsgInvalidEnvFloat, key, valueStr, defaultValue)
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,148 @@
+package swagger
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Constants for APIs deployed to several environments
+const (
+	queryParamEnvironment   = "env" // Selects the environment of a served spec, e.g. /api/orders?env=staging
+	environmentKeySeparator = "~"   // Separates API and environment in the names of environment entries
+
+	logMsgEnvironmentSkipped  = "Skipping environment %q of API %s: %s"
+	errMsgEnvironmentNotFound = "Environment not found"
+)
+
+// APIEnvironment is one deployment of an API, such as dev, staging or prod.
+type APIEnvironment struct {
+	Name    string `json:"name"`
+	URL     string `json:"url,omitempty"`     // URL to fetch the environment's spec; defaults to the API's URL
+	BaseURL string `json:"baseUrl,omitempty"` // Where requests are sent; defaults to the servers of the spec
+}
+
+// expandEnvironments replaces every API with environments by one entry per environment. The
+// entry of the default environment keeps the API's name, so it is listed and served as before;
+// the others are named "<name>~<environment>" and only reachable by selecting their environment.
+// Each entry has its own spec, proxy route and allowed hosts.
+func (s *Server) expandEnvironments(apis []APIMetadata) []APIMetadata {
+	var expanded []APIMetadata
+	for _, api := range apis {
+		if len(api.Environments) == 0 || api.Error != "" {
+			expanded = append(expanded, api)
+			continue
+		}
+
+		var environments []APIEnvironment
+		for _, environment := range api.Environments {
+			switch {
+			case environment.Name == "" || strings.ContainsAny(environment.Name, environmentKeySeparator+"/@"):
+				s.logger.Warnf(logMsgEnvironmentSkipped, environment.Name, api.Name, "invalid name")
+				continue
+			case containsEnvironment(environments, environment.Name):
+				s.logger.Warnf(logMsgEnvironmentSkipped, environment.Name, api.Name, "duplicate name")
+				continue
+			}
+			if environment.BaseURL != "" {
+				if baseURL, err := url.Parse(environment.BaseURL); err != nil || !baseURL.IsAbs() {
+					s.logger.Warnf(logMsgEnvironmentSkipped, environment.Name, api.Name, "baseUrl must be an absolute URL")
+					continue
+				}
+			}
+			if environment.URL == "" {
+				environment.URL = api.URL
+			}
+			environments = append(environments, environment)
+		}
+		if len(environments) == 0 {
+			expanded = append(expanded, api)
+			continue
+		}
+
+		api.Environments = environments
+		defaultName := api.DefaultEnvironment
+		if !containsEnvironment(environments, defaultName) {
+			defaultName = environments[0].Name
+		}
+		api.DefaultEnvironment = defaultName
+		for _, environment := range environments {
+			entry := api
+			entry.URL = environment.URL
+			entry.BaseURL = environment.BaseURL
+			entry.Environment = environment.Name
+			if environment.Name != defaultName {
+				entry.Name = api.Name + environmentKeySeparator + environment.Name
+				entry.Parent = api.Name
+			}
+			expanded = append(expanded, entry)
+		}
+	}
+	return expanded
+}
+
+// containsEnvironment reports whether environments has one with the given name.
+func containsEnvironment(environments []APIEnvironment, name string) bool {
+	for _, environment := range environments {
+		if environment.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isEnvironmentEntry reports whether an entry is a non-default environment of an API, which is
+// not listed in /swagger-specs.
+func isEnvironmentEntry(api APIMetadata) bool {
+	return api.Environment != "" && api.Parent != "" && api.Environment != api.DefaultEnvironment
+}
+
+// environmentEntry returns the key and metadata of an environment of the API registered as key.
+// It returns false if the API has no such environment. Entries of grouped APIs (see
+// expandSwaggerConfig) map to the same group of the other environment: environments are expanded
+// first, so their keys are "<name>~<environment>:<group>".
+func (s *Server) environmentEntry(key string, metadata APIMetadata, environment string) (string, APIMetadata, bool) {
+	if environment == metadata.Environment {
+		return key, metadata, true
+	}
+	if !containsEnvironment(metadata.Environments, environment) {
+		return "", APIMetadata{}, false
+	}
+
+	name := metadata.Name
+	if metadata.Group != "" {
+		name = strings.TrimSuffix(name, groupKeySeparator+metadata.Group)
+	}
+	if metadata.Environment != metadata.DefaultEnvironment {
+		name = strings.TrimSuffix(name, environmentKeySeparator+metadata.Environment)
+	}
+	entry := metadata
+	entry.Name = name
+	if environment != metadata.DefaultEnvironment {
+		entry.Name += environmentKeySeparator + environment
+	}
+	if metadata.Group != "" {
+		entry.Name += groupKeySeparator + metadata.Group
+	}
+	entryKey := specKey(entry)
+
+	s.specsMux.RLock()
+	defer s.specsMux.RUnlock()
+	entry, exists := s.specs[entryKey]
+	return entryKey, entry, exists
+}
+
+// applyBaseURL replaces the servers of a spec with the base URL configured for its API, so that
+// requests only go to (and the proxy only allows) that server.
+func applyBaseURL(spec map[string]interface{}, baseURL string) {
+	base, err := url.Parse(baseURL)
+	if baseURL == "" || err != nil || !base.IsAbs() {
+		return
+	}
+	if swaggerVersion, _ := spec["swagger"].(string); swaggerVersion == "2.0" {
+		spec["schemes"] = []interface{}{base.Scheme}
+		spec["host"] = base.Host
+		spec["basePath"] = "/" + strings.Trim(base.Path, "/")
+		return
+	}
+	spec["servers"] = []interface{}{map[string]interface{}{"url": strings.TrimSuffix(base.String(), "/")}}
+}
@@ -0,0 +1,124 @@
+package swagger
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpandEnvironments(t *testing.T) {
+	// environmentSummary is the part of an expanded entry the tests compare.
+	type environmentSummary struct {
+		Name, URL, BaseURL, Environment, DefaultEnvironment, Parent string
+	}
+
+	tests := []struct {
+		name string
+		api  APIMetadata
+		want []environmentSummary
+	}{
+		{
+			name: "no environments",
+			api:  APIMetadata{Name: "pets", URL: "http://pets/openapi.json"},
+			want: []environmentSummary{{Name: "pets", URL: "http://pets/openapi.json"}},
+		},
+		{
+			name: "first environment is the default",
+			api: APIMetadata{Name: "orders", URL: "http://orders/openapi.json", Environments: []APIEnvironment{
+				{Name: "dev", BaseURL: "https://dev.example.com"},
+				{Name: "prod", URL: "http://orders-prod/openapi.json"},
+			}},
+			want: []environmentSummary{
+				{Name: "orders", URL: "http://orders/openapi.json", BaseURL: "https://dev.example.com", Environment: "dev", DefaultEnvironment: "dev"},
+				{Name: "orders~prod", URL: "http://orders-prod/openapi.json", Environment: "prod", DefaultEnvironment: "dev", Parent: "orders"},
+			},
+		},
+		{
+			name: "configured default",
+			api: APIMetadata{Name: "orders", URL: "http://orders/openapi.json", DefaultEnvironment: "prod", Environments: []APIEnvironment{
+				{Name: "dev"},
+				{Name: "prod"},
+			}},
+			want: []environmentSummary{
+				{Name: "orders~dev", URL: "http://orders/openapi.json", Environment: "dev", DefaultEnvironment: "prod", Parent: "orders"},
+				{Name: "orders", URL: "http://orders/openapi.json", Environment: "prod", DefaultEnvironment: "prod"},
+			},
+		},
+		{
+			name: "invalid and duplicate environments are skipped",
+			api: APIMetadata{Name: "orders", URL: "http://orders/openapi.json", DefaultEnvironment: "missing", Environments: []APIEnvironment{
+				{Name: ""},
+				{Name: "a~b"},
+				{Name: "qa"},
+				{Name: "qa"},
+				{Name: "rel", BaseURL: "/relative"},
+			}},
+			want: []environmentSummary{
+				{Name: "orders", URL: "http://orders/openapi.json", Environment: "qa", DefaultEnvironment: "qa"},
+			},
+		},
+		{
+			name: "no valid environment",
+			api:  APIMetadata{Name: "orders", URL: "http://orders/openapi.json", Environments: []APIEnvironment{{Name: "x/y"}}},
+			want: []environmentSummary{{Name: "orders", URL: "http://orders/openapi.json"}},
+		},
+		{
+			name: "failed APIs are kept as they are",
+			api:  APIMetadata{Name: "broken", Error: "not found", Environments: []APIEnvironment{{Name: "dev"}}},
+			want: []environmentSummary{{Name: "broken"}},
+		},
+	}
+	s := NewServer()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []environmentSummary
+			for _, entry := range s.expandEnvironments([]APIMetadata{tt.api}) {
+				got = append(got, environmentSummary{entry.Name, entry.URL, entry.BaseURL, entry.Environment, entry.DefaultEnvironment, entry.Parent})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expandEnvironments() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnvironmentEntry(t *testing.T) {
+	environments := []APIEnvironment{{Name: "dev"}, {Name: "prod"}}
+	entry := func(name, environment, group string) APIMetadata {
+		return APIMetadata{Name: name, Environment: environment, DefaultEnvironment: "dev", Environments: environments, Group: group}
+	}
+	s := NewServer()
+	s.specs = map[string]APIMetadata{
+		"orders":            entry("orders", "dev", ""),
+		"orders~prod":       entry("orders~prod", "prod", ""),
+		"orders:admin":      entry("orders:admin", "dev", "admin"),
+		"orders~prod:admin": entry("orders~prod:admin", "prod", "admin"),
+		"orders:public":     entry("orders:public", "dev", "public"),
+	}
+
+	tests := []struct {
+		name        string
+		key         string
+		environment string
+		wantKey     string
+		wantOK      bool
+	}{
+		{name: "same environment", key: "orders", environment: "dev", wantKey: "orders", wantOK: true},
+		{name: "default to other", key: "orders", environment: "prod", wantKey: "orders~prod", wantOK: true},
+		{name: "other to default", key: "orders~prod", environment: "dev", wantKey: "orders", wantOK: true},
+		{name: "group to other environment", key: "orders:admin", environment: "prod", wantKey: "orders~prod:admin", wantOK: true},
+		{name: "group back to default", key: "orders~prod:admin", environment: "dev", wantKey: "orders:admin", wantOK: true},
+		{name: "group missing in other environment", key: "orders:public", environment: "prod", wantKey: "orders~prod:public", wantOK: false},
+		{name: "unknown environment", key: "orders", environment: "qa", wantKey: "", wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, metadata, ok := s.environmentEntry(tt.key, s.specs[tt.key], tt.environment)
+			if key != tt.wantKey || ok != tt.wantOK {
+				t.Fatalf("environmentEntry(%q, %q) = %q, %v, want %q, %v", tt.key, tt.environment, key, ok, tt.wantKey, tt.wantOK)
+			}
+			if ok && metadata.Environment != tt.environment {
+				t.Errorf("environmentEntry(%q, %q) returned environment %q", tt.key, tt.environment, metadata.Environment)
+			}
+		})
+	}
+}
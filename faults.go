@@ -0,0 +1,293 @@
+package swagger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Constants for fault injection
+const (
+	envVarProxyFaultInjection = "PROXY_FAULT_INJECTION" // Enables fault injection; all fault settings are ignored otherwise
+
+	headerFaultInjection = "X-Fault-Injection" // Per-request faults, e.g. "latency=500ms, error=503"
+	headerFaultInjected  = "X-Fault-Injected"  // Faults applied to a response
+
+	faultLatency  = "latency"
+	faultError    = "error"
+	faultReset    = "reset"
+	faultTruncate = "truncate"
+
+	faultDistributionFixed       = "fixed"
+	faultDistributionUniform     = "uniform"
+	faultDistributionNormal      = "normal"
+	faultDistributionExponential = "exponential"
+
+	faultMaxLatency       = time.Minute // Upper bound of injected latency
+	faultTruncateFallback = 512         // Bytes passed before truncating a body of unknown length
+
+	logMsgFaultInjected     = "Injecting %s into proxy request from %s to %s"
+	logMsgFaultHeaderIgnore = "Ignoring invalid %s header %q: %v"
+	errMsgFaultInjected     = "Injected fault: status %d"
+)
+
+// FaultRule describes the faults injected into proxied requests. Each fault applies to a share
+// of requests given by its rate (0 to 1).
+type FaultRule struct {
+	Latency      *LatencyFault `json:"latency,omitempty"`
+	Error        *ErrorFault   `json:"error,omitempty"`
+	ResetRate    float64       `json:"resetRate,omitempty"`    // Share of requests whose connection is reset
+	TruncateRate float64       `json:"truncateRate,omitempty"` // Share of responses whose body is cut short
+}
+
+// LatencyFault delays requests before they are forwarded. The delay follows a distribution:
+// "fixed" (meanMs), "uniform" (minMs to maxMs), "normal" (meanMs, stdDevMs) or "exponential" (meanMs).
+type LatencyFault struct {
+	Distribution string  `json:"distribution,omitempty"` // Defaults to "fixed"
+	MeanMillis   float64 `json:"meanMs,omitempty"`
+	MinMillis    float64 `json:"minMs,omitempty"`
+	MaxMillis    float64 `json:"maxMs,omitempty"`
+	StdDevMillis float64 `json:"stdDevMs,omitempty"`
+	Rate         float64 `json:"rate,omitempty"` // Defaults to 1 (every request)
+}
+
+// ErrorFault answers requests with an error status instead of forwarding them.
+type ErrorFault struct {
+	Status int     `json:"status,omitempty"` // Defaults to 503
+	Rate   float64 `json:"rate,omitempty"`   // Defaults to 1 (every request)
+}
+
+// FaultConfig configures the faults injected into proxied requests to an API: a rule for all of
+// its operations, and rules for single operations that replace the faults they set.
+type FaultConfig struct {
+	FaultRule
+	Operations map[string]FaultRule `json:"operations,omitempty"` // By "METHOD /path/{template}" or operationId
+}
+
+// faultTruncateKey is the context key that marks a proxied request whose response is truncated.
+type faultTruncateKey struct{}
+
+// injectFaults applies the faults configured for a proxied request, if fault injection is
+// enabled: it delays the request, or answers it with an error status or a connection reset
+// without contacting the backend, or marks its response for truncation. It returns the request
+// to forward, or false if the request has been answered.
+func (s *Server) injectFaults(w http.ResponseWriter, r *http.Request, target *proxyTarget) (*http.Request, bool) {
+	header := r.Header.Get(headerFaultInjection)
+	r.Header.Del(headerFaultInjection) // Never forwarded, whether or not faults are enabled
+	if !s.faultInjection {
+		return r, true
+	}
+
+	rule := s.faultRule(r, target)
+	if header != "" {
+		requested, err := parseFaultHeader(header)
+		if err != nil {
+			s.logger.Warnf(logMsgFaultHeaderIgnore, headerFaultInjection, header, err)
+		} else {
+			rule = mergeFaultRules(rule, requested)
+		}
+	}
+	api := target.apiKey
+	if api == "" {
+		api = target.url.Host
+	}
+	inject := func(fault, detail string) {
+		s.metrics.faultsInjected.inc(api, fault)
+		s.logger.Infof(logMsgFaultInjected, detail, clientIP(r), target.url.Redacted())
+		w.Header().Add(headerFaultInjected, detail)
+	}
+
+	if rule.Latency != nil && roll(rule.Latency.Rate, 1) {
+		delay := rule.Latency.sample()
+		inject(faultLatency, fmt.Sprintf("%s=%s", faultLatency, delay.Round(time.Millisecond)))
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-r.Context().Done():
+			timer.Stop()
+			return r, false // The client went away
+		}
+	}
+	if roll(rule.ResetRate, 0) {
+		inject(faultReset, faultReset)
+		resetConnection(w)
+		return r, false
+	}
+	if rule.Error != nil && roll(rule.Error.Rate, 1) {
+		status := rule.Error.Status
+		if status < 400 || status > 599 {
+			status = http.StatusServiceUnavailable
+		}
+		inject(faultError, fmt.Sprintf("%s=%d", faultError, status))
+		s.writeJSON(w, r, status, &proxyError{Error: fmt.Sprintf(errMsgFaultInjected, status), API: target.apiKey})
+		return r, false
+	}
+	if roll(rule.TruncateRate, 0) {
+		inject(faultTruncate, faultTruncate)
+		r = r.WithContext(context.WithValue(r.Context(), faultTruncateKey{}, true))
+	}
+	return r, true
+}
+
+// faultRule returns the configured faults of the API and operation a request targets.
+func (s *Server) faultRule(r *http.Request, target *proxyTarget) FaultRule {
+	if target.apiKey == "" {
+		return FaultRule{} // Allowlisted hosts only get faults requested by header
+	}
+	s.specsMux.RLock()
+	config := s.specs[target.apiKey].Faults
+	s.specsMux.RUnlock()
+	if config == nil {
+		return FaultRule{}
+	}
+	rule := config.FaultRule
+	if len(config.Operations) == 0 {
+		return rule
+	}
+
+	spec, err := s.cachedSpecFor(target.apiKey)
+	if err != nil {
+		return rule
+	}
+	match, _ := findOperation(spec, r.Method, target.url)
+	if match == nil {
+		return rule
+	}
+	if operation, ok := config.Operations[match.Method+" "+match.Path]; ok {
+		return mergeFaultRules(rule, operation)
+	}
+	if operationID, _ := match.Operation["operationId"].(string); operationID != "" {
+		if operation, ok := config.Operations[operationID]; ok {
+			return mergeFaultRules(rule, operation)
+		}
+	}
+	return rule
+}
+
+// mergeFaultRules returns base with the faults set in override replacing its own.
+func mergeFaultRules(base, override FaultRule) FaultRule {
+	if override.Latency != nil {
+		base.Latency = override.Latency
+	}
+	if override.Error != nil {
+		base.Error = override.Error
+	}
+	if override.ResetRate > 0 {
+		base.ResetRate = override.ResetRate
+	}
+	if override.TruncateRate > 0 {
+		base.TruncateRate = override.TruncateRate
+	}
+	return base
+}
+
+// parseFaultHeader parses the X-Fault-Injection header, a comma-separated list of faults applied
+// to the request: "latency=<duration>", "error[=<status>]", "reset" and "truncate".
+func parseFaultHeader(value string) (FaultRule, error) {
+	var rule FaultRule
+	for _, item := range strings.Split(value, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(item), "=")
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "":
+		case faultLatency:
+			delay, err := time.ParseDuration(strings.TrimSpace(arg))
+			if err != nil {
+				return FaultRule{}, err
+			}
+			rule.Latency = &LatencyFault{MeanMillis: float64(delay) / float64(time.Millisecond), Rate: 1}
+		case faultError:
+			status := http.StatusServiceUnavailable
+			if arg != "" {
+				var err error
+				if status, err = strconv.Atoi(strings.TrimSpace(arg)); err != nil {
+					return FaultRule{}, err
+				}
+			}
+			rule.Error = &ErrorFault{Status: status, Rate: 1}
+		case faultReset:
+			rule.ResetRate = 1
+		case faultTruncate:
+			rule.TruncateRate = 1
+		default:
+			return FaultRule{}, fmt.Errorf("unknown fault %q", name)
+		}
+	}
+	return rule, nil
+}
+
+// sample draws a delay from the latency distribution, bounded by 0 and faultMaxLatency.
+func (l *LatencyFault) sample() time.Duration {
+	var millis float64
+	switch l.Distribution {
+	case faultDistributionUniform:
+		millis = l.MinMillis + rand.Float64()*math.Max(l.MaxMillis-l.MinMillis, 0)
+	case faultDistributionNormal:
+		millis = l.MeanMillis + rand.NormFloat64()*l.StdDevMillis
+	case faultDistributionExponential:
+		millis = rand.ExpFloat64() * l.MeanMillis
+	default: // faultDistributionFixed
+		millis = l.MeanMillis
+	}
+	delay := time.Duration(millis * float64(time.Millisecond))
+	return min(max(delay, 0), faultMaxLatency)
+}
+
+// roll reports whether a fault with the given rate applies to this request. A zero rate means
+// def, so that rates can be omitted where a fault is configured on its own.
+func roll(rate, def float64) bool {
+	if rate == 0 {
+		rate = def
+	}
+	return rate > 0 && rand.Float64() < rate
+}
+
+// resetConnection drops the client connection without a response: a TCP reset for HTTP/1.x,
+// a stream reset for HTTP/2.
+func resetConnection(w http.ResponseWriter) {
+	conn, _, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler) // HTTP/2 can't be hijacked; aborting resets the stream
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		_ = tcpConn.SetLinger(0) // Close with RST instead of FIN
+	}
+	_ = conn.Close()
+}
+
+// truncatedBody passes the first remaining bytes of a response body and then ends it. The
+// client receives the announced Content-Length but fewer bytes, after which the connection is
+// closed; bodies of unknown length are aborted mid-stream instead.
+type truncatedBody struct {
+	io.ReadCloser
+	remaining int64
+	end       error // io.EOF, or io.ErrUnexpectedEOF to abort the response
+}
+
+// newTruncatedBody truncates a response body to half its announced length, or to
+// faultTruncateFallback bytes if the length is unknown.
+func newTruncatedBody(resp *http.Response) io.ReadCloser {
+	if resp.ContentLength > 0 {
+		return &truncatedBody{ReadCloser: resp.Body, remaining: resp.ContentLength / 2, end: io.EOF}
+	}
+	return &truncatedBody{ReadCloser: resp.Body, remaining: faultTruncateFallback, end: io.ErrUnexpectedEOF}
+}
+
+// Read implements io.Reader.
+func (b *truncatedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		return 0, b.end
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
@@ -0,0 +1,42 @@
+package swagger
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFaultHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    FaultRule
+		wantErr bool
+	}{
+		{name: "empty", value: "", want: FaultRule{}},
+		{name: "latency", value: "latency=1.5s", want: FaultRule{Latency: &LatencyFault{MeanMillis: 1500, Rate: 1}}},
+		{name: "default error status", value: "error", want: FaultRule{Error: &ErrorFault{Status: 503, Rate: 1}}},
+		{name: "error status", value: "error=429", want: FaultRule{Error: &ErrorFault{Status: 429, Rate: 1}}},
+		{name: "reset", value: "reset", want: FaultRule{ResetRate: 1}},
+		{name: "truncate", value: "truncate", want: FaultRule{TruncateRate: 1}},
+		{name: "combined with spaces and case", value: " Latency = 200ms ,ERROR=500, truncate,", want: FaultRule{
+			Latency:      &LatencyFault{MeanMillis: 200, Rate: 1},
+			Error:        &ErrorFault{Status: 500, Rate: 1},
+			TruncateRate: 1,
+		}},
+		{name: "invalid duration", value: "latency=soon", wantErr: true},
+		{name: "missing duration", value: "latency", wantErr: true},
+		{name: "invalid status", value: "error=oops", wantErr: true},
+		{name: "unknown fault", value: "reset, explode", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseFaultHeader(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFaultHeader(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseFaultHeader(%q) = %+v, want %+v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
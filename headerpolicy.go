@@ -0,0 +1,154 @@
+package swagger
+
+import (
+	"net"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Constants for the proxy header policy
+const (
+	envVarProxyRequestHeaderAllow  = "PROXY_REQUEST_HEADER_ALLOW"  // If set, only matching request headers are forwarded
+	envVarProxyRequestHeaderDeny   = "PROXY_REQUEST_HEADER_DENY"   // Request headers dropped in addition to the defaults
+	envVarProxyResponseHeaderAllow = "PROXY_RESPONSE_HEADER_ALLOW" // If set, only matching response headers are returned
+	envVarProxyResponseHeaderDeny  = "PROXY_RESPONSE_HEADER_DENY"  // Response headers dropped in addition to the defaults
+
+	headerCookie    = "Cookie"
+	headerSetCookie = "Set-Cookie"
+)
+
+// tracingHeaders are the headers of common tracing systems. They describe this deployment's
+// internal call graph and are dropped in both directions by default.
+var tracingHeaders = []string{
+	"Traceparent", "Tracestate", "Baggage",
+	"B3", "X-B3-*", "Uber-Trace-Id", "Uberctx-*",
+	"X-Amzn-Trace-Id", "X-Cloud-Trace-Context", "X-Datadog-*",
+	"X-Envoy-*",
+}
+
+// defaultRequestHeaderDeny are request headers that belong to this portal rather than to the
+// upstream API: its cookies, credentials for the portal or an HTTP proxy in front of it, and
+// tracing headers. APIs that take bearer tokens from "Try it out" opt in with ForwardAuthorization.
+var defaultRequestHeaderDeny = append([]string{headerCookie, headerAuthorization, "Proxy-Authorization"}, tracingHeaders...)
+
+// defaultResponseHeaderDeny are response headers that are not returned to the browser.
+// Set-Cookie is filtered per cookie instead (see filterSetCookies).
+var defaultResponseHeaderDeny = tracingHeaders
+
+// HeaderPolicy adjusts which headers the proxy passes for one API.
+type HeaderPolicy struct {
+	Request  HeaderRules `json:"request,omitempty"`  // Headers sent from the browser to the API
+	Response HeaderRules `json:"response,omitempty"` // Headers returned from the API to the browser
+
+	// ForwardAuthorization passes the browser's Authorization header to the API, for APIs that
+	// are called with credentials entered in "Try it out". Request deny rules still apply.
+	ForwardAuthorization bool `json:"forwardAuthorization,omitempty"`
+}
+
+// HeaderRules lists header name patterns (case-insensitive, "*" wildcards such as "X-B3-*").
+// Deny always wins. If Allow is set, only matching headers pass and the default and global
+// rules are ignored, so Allow can also let through headers denied by default, such as Cookie.
+type HeaderRules struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+// proxyHeaderRules are the server-wide header rules of one direction.
+type proxyHeaderRules struct {
+	allow []string // If non-empty, only matching headers pass
+	deny  []string // Defaults plus configured patterns
+}
+
+// proxyHeaderPolicy is the server-wide header policy, configured from the PROXY_*_HEADER_* variables.
+type proxyHeaderPolicy struct {
+	request  proxyHeaderRules
+	response proxyHeaderRules
+}
+
+// loadHeaderPolicy builds the server-wide header policy from the defaults and the environment.
+func loadHeaderPolicy() proxyHeaderPolicy {
+	return proxyHeaderPolicy{
+		request: proxyHeaderRules{
+			allow: getEnvList(envVarProxyRequestHeaderAllow),
+			deny:  append(append([]string{}, defaultRequestHeaderDeny...), getEnvList(envVarProxyRequestHeaderDeny)...),
+		},
+		response: proxyHeaderRules{
+			allow: getEnvList(envVarProxyResponseHeaderAllow),
+			deny:  append(append([]string{}, defaultResponseHeaderDeny...), getEnvList(envVarProxyResponseHeaderDeny)...),
+		},
+	}
+}
+
+// headerAllowed reports whether a header passes the API's rules and the server-wide rules.
+func headerAllowed(name string, api HeaderRules, global proxyHeaderRules) bool {
+	if matchHeader(api.Deny, name) {
+		return false
+	}
+	if len(api.Allow) > 0 {
+		return matchHeader(api.Allow, name)
+	}
+	if matchHeader(global.deny, name) {
+		return false
+	}
+	if len(global.allow) > 0 {
+		return matchHeader(global.allow, name)
+	}
+	return true
+}
+
+// filterHeaders removes the headers that don't pass the rules from header.
+func filterHeaders(header http.Header, api HeaderRules, global proxyHeaderRules) {
+	for name := range header {
+		if !headerAllowed(name, api, global) {
+			header.Del(name)
+		}
+	}
+}
+
+// filterRequestHeaders removes the request headers that don't pass the API's rules and the
+// server-wide rules from header, keeping Authorization if the API forwards it.
+func filterRequestHeaders(header http.Header, api HeaderPolicy, global proxyHeaderRules) {
+	authorization := header.Values(headerAuthorization)
+	filterHeaders(header, api.Request, global)
+	if api.ForwardAuthorization && len(authorization) > 0 && !matchHeader(api.Request.Deny, headerAuthorization) {
+		header[headerAuthorization] = authorization
+	}
+}
+
+// matchHeader reports whether name matches one of the patterns, ignoring case.
+func matchHeader(patterns []string, name string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(strings.ToLower(pattern), name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// filterSetCookies drops the Set-Cookie headers of a response whose Domain attribute doesn't
+// cover host, the portal host the browser sees. Such cookies belong to the upstream's domain
+// and can't be stored for the portal anyway.
+func filterSetCookies(header http.Header, host string) {
+	values := header.Values(headerSetCookie)
+	if len(values) == 0 {
+		return
+	}
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	host = strings.ToLower(host)
+
+	header.Del(headerSetCookie)
+	for _, value := range values {
+		cookies := (&http.Response{Header: http.Header{headerSetCookie: []string{value}}}).Cookies()
+		if len(cookies) == 0 {
+			continue // Malformed
+		}
+		domain := strings.ToLower(strings.TrimPrefix(cookies[0].Domain, "."))
+		if domain == "" || host == domain || strings.HasSuffix(host, "."+domain) {
+			header.Add(headerSetCookie, value)
+		}
+	}
+}
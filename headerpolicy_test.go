@@ -0,0 +1,90 @@
+package swagger
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestHeaderAllowed(t *testing.T) {
+	defaults := proxyHeaderRules{deny: defaultRequestHeaderDeny}
+	restricted := proxyHeaderRules{allow: []string{"Accept", "Content-*"}, deny: defaultRequestHeaderDeny}
+
+	tests := []struct {
+		name   string
+		header string
+		api    HeaderRules
+		global proxyHeaderRules
+		want   bool
+	}{
+		{name: "ordinary header", header: "Accept", global: defaults, want: true},
+		{name: "cookie denied by default", header: "Cookie", global: defaults, want: false},
+		{name: "authorization denied by default", header: "Authorization", global: defaults, want: false},
+		{name: "tracing wildcard", header: "X-B3-TraceId", global: defaults, want: false},
+		{name: "case-insensitive", header: "traceparent", global: defaults, want: false},
+		{name: "global allow list", header: "Content-Type", global: restricted, want: true},
+		{name: "not in global allow list", header: "X-Custom", global: restricted, want: false},
+		{name: "global deny beats global allow", header: "Cookie", global: proxyHeaderRules{allow: []string{"*"}, deny: defaultRequestHeaderDeny}, want: false},
+		{name: "API deny", header: "X-Internal-Id", api: HeaderRules{Deny: []string{"X-Internal-*"}}, global: defaults, want: false},
+		{name: "API allow overrides defaults", header: "Cookie", api: HeaderRules{Allow: []string{"Cookie"}}, global: defaults, want: true},
+		{name: "API allow overrides global allow", header: "X-Custom", api: HeaderRules{Allow: []string{"X-Custom"}}, global: restricted, want: true},
+		{name: "not in API allow list", header: "Accept", api: HeaderRules{Allow: []string{"Cookie"}}, global: defaults, want: false},
+		{name: "API deny beats API allow", header: "Cookie", api: HeaderRules{Allow: []string{"*"}, Deny: []string{"cookie"}}, global: defaults, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := headerAllowed(tt.header, tt.api, tt.global); got != tt.want {
+				t.Errorf("headerAllowed(%q) = %v, want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterRequestHeaders(t *testing.T) {
+	global := proxyHeaderRules{deny: defaultRequestHeaderDeny}
+
+	tests := []struct {
+		name   string
+		policy HeaderPolicy
+		want   http.Header
+	}{
+		{name: "default drops authorization", policy: HeaderPolicy{},
+			want: http.Header{"Accept": {"application/json"}}},
+		{name: "forwarded authorization", policy: HeaderPolicy{ForwardAuthorization: true},
+			want: http.Header{"Accept": {"application/json"}, "Authorization": {"Bearer abc"}}},
+		{name: "forwarded despite API allow list", policy: HeaderPolicy{ForwardAuthorization: true, Request: HeaderRules{Allow: []string{"Accept"}}},
+			want: http.Header{"Accept": {"application/json"}, "Authorization": {"Bearer abc"}}},
+		{name: "API deny still wins", policy: HeaderPolicy{ForwardAuthorization: true, Request: HeaderRules{Deny: []string{"Authorization"}}},
+			want: http.Header{"Accept": {"application/json"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{
+				"Accept":        {"application/json"},
+				"Authorization": {"Bearer abc"},
+				"Cookie":        {"session=portal"},
+				"Traceparent":   {"00-abc-def-01"},
+			}
+			filterRequestHeaders(header, tt.policy, global)
+			if !reflect.DeepEqual(header, tt.want) {
+				t.Errorf("filterRequestHeaders() = %v, want %v", header, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterSetCookies(t *testing.T) {
+	header := http.Header{headerSetCookie: {
+		"host=1",
+		"parent=1; Domain=example.com",
+		"dotted=1; Domain=.portal.example.com",
+		"other=1; Domain=upstream.internal",
+		"sibling=1; Domain=api.example.com",
+	}}
+	filterSetCookies(header, "portal.example.com:8443")
+
+	want := []string{"host=1", "parent=1; Domain=example.com", "dotted=1; Domain=.portal.example.com"}
+	if got := header.Values(headerSetCookie); !reflect.DeepEqual(got, want) {
+		t.Errorf("filterSetCookies() kept %v, want %v", got, want)
+	}
+}
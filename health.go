@@ -0,0 +1,193 @@
+package swagger
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Constants for the health and readiness probes
+const (
+	httpPathHealthz = "/healthz" // Process alive; served with and without SWAGGER_BASE_PATH
+	httpPathReadyz  = "/readyz"  // Ready for traffic; served with and without SWAGGER_BASE_PATH
+
+	envVarReadinessStaleness = "READINESS_STALENESS_SECONDS" // How long ago Kubernetes may last have been reached; 0 to skip the check
+	envVarReadinessMinSpecs  = "READINESS_MIN_SPECS"         // Specs that must be loaded before the server is ready
+
+	defaultReadinessStaleness = 60 // seconds
+	queryParamVerbose         = "verbose"
+	contentTypeText           = "text/plain; charset=utf-8"
+
+	checkShutdown   = "shutdown"
+	checkDiscovery  = "discovery"
+	checkKubernetes = "kubernetes"
+	checkSpecs      = "specs"
+
+	statusOK       = "ok"
+	statusNotReady = "not ready"
+)
+
+// discoveryState tracks the results of the discovery rounds reported with ReportDiscovery.
+type discoveryState struct {
+	mux      sync.Mutex
+	rounds   int64
+	clusters map[string]*clusterDiscovery
+}
+
+// clusterDiscovery is the discovery status of one cluster, shown by /readyz?verbose.
+type clusterDiscovery struct {
+	Cluster     string     `json:"cluster,omitempty"` // Empty for the single cluster of single-cluster mode
+	LastAttempt time.Time  `json:"lastAttempt"`
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	LastError   string     `json:"lastError,omitempty"` // Error of the last attempt, if it failed
+}
+
+// probeCheck is the outcome of one readiness check.
+type probeCheck struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+// probeReport is the detail view of /healthz?verbose and /readyz?verbose.
+type probeReport struct {
+	Status    string             `json:"status"`
+	StartedAt time.Time          `json:"startedAt"`
+	Checks    []probeCheck       `json:"checks,omitempty"`
+	Specs     *int               `json:"specs,omitempty"` // Loaded specs
+	Clusters  []clusterDiscovery `json:"clusters,omitempty"`
+}
+
+// ReportDiscovery records the outcome of a discovery round: the error of loading the specs of
+// each cluster, nil if it succeeded. The server is not ready until the first round is reported.
+func (s *Server) ReportDiscovery(results map[string]error) {
+	now := time.Now().UTC()
+	s.discovery.mux.Lock()
+	defer s.discovery.mux.Unlock()
+	s.discovery.rounds++
+	for cluster, err := range results {
+		status, ok := s.discovery.clusters[cluster]
+		if !ok {
+			status = &clusterDiscovery{Cluster: cluster}
+			s.discovery.clusters[cluster] = status
+		}
+		status.LastAttempt = now
+		status.LastError = ""
+		if err != nil {
+			status.LastError = err.Error()
+			continue
+		}
+		lastSuccess := now
+		status.LastSuccess = &lastSuccess
+	}
+}
+
+// readiness runs the readiness checks and returns their outcomes, the number of loaded specs and
+// the discovery status of every cluster.
+func (s *Server) readiness() ([]probeCheck, int, []clusterDiscovery) {
+	checks := []probeCheck{{Name: checkShutdown, OK: !s.draining.Load()}}
+	if !checks[0].OK {
+		checks[0].Message = "draining in-flight requests"
+	}
+
+	s.discovery.mux.Lock()
+	rounds := s.discovery.rounds
+	clusters := make([]clusterDiscovery, 0, len(s.discovery.clusters))
+	for _, status := range s.discovery.clusters {
+		clusters = append(clusters, *status)
+	}
+	s.discovery.mux.Unlock()
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].Cluster < clusters[j].Cluster })
+
+	discovery := probeCheck{Name: checkDiscovery, OK: rounds > 0}
+	if !discovery.OK {
+		discovery.Message = "initial discovery has not completed"
+	}
+	checks = append(checks, discovery)
+
+	// Kubernetes counts as reachable while any cluster was reached recently: the specs of the
+	// others are kept, so a single unreachable cluster doesn't take the server out of service.
+	if s.readinessStale > 0 && rounds > 0 {
+		kubernetes := probeCheck{Name: checkKubernetes}
+		var lastSuccess time.Time
+		for _, status := range clusters {
+			if status.LastSuccess != nil && status.LastSuccess.After(lastSuccess) {
+				lastSuccess = *status.LastSuccess
+			}
+		}
+		switch {
+		case lastSuccess.IsZero():
+			kubernetes.Message = "no cluster has been reached"
+		case time.Since(lastSuccess) > s.readinessStale:
+			kubernetes.Message = fmt.Sprintf("no cluster reached for %s", time.Since(lastSuccess).Round(time.Second))
+		default:
+			kubernetes.OK = true
+		}
+		checks = append(checks, kubernetes)
+	}
+
+	loaded := 0
+	s.specsMux.RLock()
+	for _, api := range s.specs {
+		if api.Error == "" && !isEnvironmentEntry(api) {
+			loaded++
+		}
+	}
+	s.specsMux.RUnlock()
+	if s.readinessMinSpecs > 0 {
+		specs := probeCheck{Name: checkSpecs, OK: loaded >= s.readinessMinSpecs}
+		if !specs.OK {
+			specs.Message = fmt.Sprintf("%d of at least %d specs loaded", loaded, s.readinessMinSpecs)
+		}
+		checks = append(checks, specs)
+	}
+	return checks, loaded, clusters
+}
+
+// serveHealthz reports that the process is alive. It doesn't depend on discovery or shutdown, so
+// that a liveness probe doesn't restart the server while Kubernetes is unreachable or draining.
+func (s *Server) serveHealthz(w http.ResponseWriter, r *http.Request) {
+	if _, verbose := r.URL.Query()[queryParamVerbose]; verbose {
+		s.writeJSON(w, r, http.StatusOK, probeReport{Status: statusOK, StartedAt: s.startedAt})
+		return
+	}
+	w.Header().Set(headerContentType, contentTypeText)
+	fmt.Fprintln(w, statusOK)
+}
+
+// serveReadyz reports whether the server accepts traffic: 503 while shutting down, before the
+// first discovery round, when Kubernetes hasn't been reached within READINESS_STALENESS_SECONDS,
+// or with fewer specs than READINESS_MIN_SPECS. The "verbose" query parameter selects a JSON view
+// of every check.
+func (s *Server) serveReadyz(w http.ResponseWriter, r *http.Request) {
+	checks, loaded, clusters := s.readiness()
+	statusCode, status := http.StatusOK, statusOK
+	var failures []string
+	for _, check := range checks {
+		if !check.OK {
+			statusCode, status = http.StatusServiceUnavailable, statusNotReady
+			failures = append(failures, check.Name+": "+check.Message)
+		}
+	}
+
+	if _, verbose := r.URL.Query()[queryParamVerbose]; verbose {
+		s.writeJSON(w, r, statusCode, probeReport{
+			Status:    status,
+			StartedAt: s.startedAt,
+			Checks:    checks,
+			Specs:     &loaded,
+			Clusters:  clusters,
+		})
+		return
+	}
+	w.Header().Set(headerContentType, contentTypeText)
+	w.WriteHeader(statusCode)
+	if len(failures) == 0 {
+		fmt.Fprintln(w, status)
+		return
+	}
+	fmt.Fprintln(w, strings.Join(failures, "\n"))
+}
@@ -0,0 +1,95 @@
+package swagger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Constants for graceful shutdown
+const (
+	envVarShutdownDrainDelay  = "SHUTDOWN_DRAIN_DELAY_SECONDS"  // Time between reporting not ready and closing the listeners
+	envVarShutdownGracePeriod = "SHUTDOWN_GRACE_PERIOD_SECONDS" // Time in-flight requests get to complete
+
+	defaultShutdownDrainDelay  = 5  // seconds
+	defaultShutdownGracePeriod = 20 // seconds
+
+	logMsgShutdownStarted   = "Shutting down: reporting not ready, closing listeners in %s"
+	logMsgShutdownDraining  = "Waiting up to %s for in-flight requests to complete"
+	logMsgShutdownForced    = "In-flight requests did not complete within %s, closing remaining connections: %v"
+	logMsgShutdownWorkers   = "Background workers did not stop in time: %v"
+	logMsgShutdownCompleted = "Server stopped"
+)
+
+// listeningServer is an HTTP server and the function that runs its listener.
+type listeningServer struct {
+	*http.Server
+	serve func() error
+}
+
+// Run serves the Swagger UI on the given port until ctx is done, then shuts down gracefully:
+// /readyz starts failing so that load balancers stop sending traffic, the listeners are closed
+// after SHUTDOWN_DRAIN_DELAY_SECONDS, in-flight requests get SHUTDOWN_GRACE_PERIOD_SECONDS to
+// complete, and background workers such as the audit log writer are stopped. It returns nil
+// after a graceful shutdown, or the error that stopped the server early.
+func (s *Server) Run(ctx context.Context, port int) error {
+	servers, err := s.newHTTPServers(port)
+	if err != nil {
+		return err
+	}
+
+	errs := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv *listeningServer) {
+			if err := srv.serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errs <- fmt.Errorf("%s: %w", srv.Addr, err)
+			}
+		}(srv)
+	}
+
+	select {
+	case err := <-errs:
+		s.shutdownServers(servers, 0, 0)
+		return err
+	case <-ctx.Done():
+	}
+
+	drainDelay := time.Duration(s.getEnvInt(envVarShutdownDrainDelay, defaultShutdownDrainDelay)) * time.Second
+	gracePeriod := time.Duration(s.getEnvInt(envVarShutdownGracePeriod, defaultShutdownGracePeriod)) * time.Second
+	s.shutdownServers(servers, drainDelay, gracePeriod)
+	s.logger.Info(logMsgShutdownCompleted)
+	return nil
+}
+
+// shutdownServers reports not ready, waits for drainDelay, and then closes the servers, giving
+// in-flight requests up to gracePeriod to complete. Background workers are stopped last, within
+// the same grace period.
+func (s *Server) shutdownServers(servers []*listeningServer, drainDelay, gracePeriod time.Duration) {
+	s.draining.Store(true)
+	s.logger.Infof(logMsgShutdownStarted, drainDelay)
+	time.Sleep(drainDelay)
+
+	s.logger.Infof(logMsgShutdownDraining, gracePeriod)
+	ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			s.logger.Warnf(logMsgShutdownForced, gracePeriod, err)
+			_ = srv.Close()
+		}
+	}
+	if err := s.stopWorkers(ctx); err != nil {
+		s.logger.Warnf(logMsgShutdownWorkers, err)
+	}
+}
+
+// stopWorkers stops the background workers of the server: pending audit events are written
+// before the writer stops.
+func (s *Server) stopWorkers(ctx context.Context) error {
+	if s.audit != nil {
+		return s.audit.close(ctx)
+	}
+	return nil
+}
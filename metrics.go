@@ -0,0 +1,100 @@
+package swagger
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Constants for the metrics endpoint
+const (
+	httpPathMetrics            = "/metrics" // Prometheus text exposition of the server's counters
+	contentTypePrometheus      = "text/plain; version=0.0.4; charset=utf-8"
+	labelValueSeparator        = "\xff" // Joins label values into map keys; can't occur in valid UTF-8 label values
+	logMsgFailedToWriteMetrics = "Failed to write metrics: %v"
+)
+
+// labelValueEscaper escapes label values as the Prometheus text format requires: only
+// backslash, double quote and line feed are escaped, other characters are written as is.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// counterVec is a monotonically increasing counter partitioned by label values, exposed in the
+// Prometheus text format.
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mux    sync.Mutex
+	values map[string]float64 // By label values joined with labelValueSeparator
+}
+
+// newCounterVec creates a counterVec with the given label names.
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+// inc increments the counter of the given label values, which must match the label names.
+func (c *counterVec) inc(labelValues ...string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.values[strings.Join(labelValues, labelValueSeparator)]++
+}
+
+// writeTo writes the counter in the Prometheus text format, series sorted by label values.
+func (c *counterVec) writeTo(b *strings.Builder) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		b.WriteString(c.name)
+		if len(c.labels) > 0 {
+			pairs := make([]string, len(c.labels))
+			for i, value := range strings.Split(key, labelValueSeparator) {
+				pairs[i] = c.labels[i] + `="` + labelValueEscaper.Replace(value) + `"`
+			}
+			b.WriteString("{" + strings.Join(pairs, ",") + "}")
+		}
+		fmt.Fprintf(b, " %g\n", c.values[key])
+	}
+}
+
+// serverMetrics are the counters exposed at /metrics.
+type serverMetrics struct {
+	proxyThrottled *counterVec
+	contractChecks *counterVec
+	faultsInjected *counterVec
+}
+
+// newServerMetrics creates the server's counters.
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		proxyThrottled: newCounterVec("swagger_proxy_throttled_requests_total",
+			"Proxied requests rejected by a rate limit.", "api", "limit"),
+		contractChecks: newCounterVec("swagger_proxy_contract_checks_total",
+			"Proxied responses checked against their API's spec, by result.", "api", "result"),
+		faultsInjected: newCounterVec("swagger_proxy_injected_faults_total",
+			"Faults injected into proxied requests.", "api", "fault"),
+	}
+}
+
+// serveMetrics serves the server's counters in the Prometheus text format.
+func (s *Server) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	s.metrics.proxyThrottled.writeTo(&b)
+	s.metrics.contractChecks.writeTo(&b)
+	s.metrics.faultsInjected.writeTo(&b)
+
+	w.Header().Set(headerContentType, contentTypePrometheus)
+	if _, err := w.Write([]byte(b.String())); err != nil {
+		s.logger.Errorf(logMsgFailedToWriteMetrics, err)
+	}
+}
@@ -0,0 +1,542 @@
+package swagger
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Constants for the mock server
+const (
+	httpPathMock            = "/mock/" // Prefix of mock routes: /mock/{api}/{path...}
+	envVarTryItOutTarget    = "TRY_IT_OUT_TARGET"
+	tryItOutTargetProxy     = "proxy" // "Try it out" requests go through the proxy to the real server
+	tryItOutTargetMock      = "mock"  // "Try it out" requests are answered by the mock server
+	queryParamTarget        = "target"
+	headerPrefer            = "Prefer"
+	headerPreferenceApplied = "Preference-Applied"
+	headerTryItOutTarget    = "X-Try-It-Out-Target" // Tells the UI where "Try it out" requests of a served spec go
+	headerAccept            = "Accept"
+	queryParamMockCode      = "__code"    // Alternative to Prefer: code=, for clients that can't set headers
+	queryParamMockExample   = "__example" // Alternative to Prefer: example=
+
+	mockMaxDepth      = 16   // Nesting depth up to which schemas are synthesized
+	mockMaxArrayItems = 16   // Upper bound of the minItems honoured for synthesized arrays
+	mockMaxValues     = 4096 // Values synthesized per response, beyond which arrays get a single item
+
+	errMsgMockRouteInvalid      = "Mock route must be /mock/{api}/{path}"
+	errMsgMockSpecUnavailable   = "Spec of API %s is unavailable"
+	errMsgMockPathNotFound      = "No operation of API %s matches %s"
+	errMsgMockMethodNotAllowed  = "Path %s of API %s has no %s operation"
+	errMsgMockResponseUndefined = "Operation %s %s of API %s documents no response with status %s"
+	errMsgMockEncodeFailed      = "Failed to encode mock response"
+	logMsgMockSpecUnavailable   = "Failed to load spec of %s for mocking: %v"
+)
+
+// Values synthesized for string formats, so that mocked responses are stable across requests.
+var mockFormatValues = map[string]string{
+	"date-time": "2024-01-01T12:00:00Z",
+	"date":      "2024-01-01",
+	"time":      "12:00:00",
+	"uuid":      "3fa85f64-5717-4562-b3fc-2c963f66afa6",
+	"email":     "user@example.com",
+	"uri":       "https://example.com",
+	"url":       "https://example.com",
+	"hostname":  "example.com",
+	"ipv4":      "192.0.2.1",
+	"ipv6":      "2001:db8::1",
+	"byte":      "c3RyaW5n",
+	"password":  "********",
+}
+
+// mockPreferences are the selectors of a Prefer header, e.g. "Prefer: code=404, example=notFound".
+type mockPreferences struct {
+	code    string // Status code of the documented response to return
+	example string // Name of the example to return
+}
+
+// serveMock answers a /mock/{api}/{path...} request from the spec of the API: the matching
+// operation's documented response is returned with its example or, lacking one, a value
+// synthesized from its schema. The response is the lowest documented 2xx unless a Prefer header
+// selects another status code or a named example. Nothing is sent to the API's backend.
+func (s *Server) serveMock(w http.ResponseWriter, r *http.Request) {
+	route := strings.TrimPrefix(s.stripBasePath(r.URL.EscapedPath()), httpPathMock)
+	escapedKey, rest, _ := strings.Cut(route, "/")
+	key, err := url.PathUnescape(escapedKey)
+	if err != nil || key == "" {
+		s.logAndSendError(w, r, http.StatusBadRequest, errMsgMockRouteInvalid, errMsgMockRouteInvalid)
+		return
+	}
+	requestPath, err := url.PathUnescape("/" + rest)
+	if err != nil {
+		s.logAndSendError(w, r, http.StatusBadRequest, errMsgMockRouteInvalid, errMsgMockRouteInvalid)
+		return
+	}
+
+	s.specsMux.RLock()
+	_, exists := s.specs[key]
+	s.specsMux.RUnlock()
+	if !exists {
+		s.logAndSendError(w, r, http.StatusNotFound, fmt.Sprintf("%s: %s", errMsgAPINotFound, key), errMsgAPINotFound)
+		return
+	}
+	spec, err := s.cachedSpecFor(key)
+	if err != nil {
+		s.logAndSendError(w, r, http.StatusBadGateway, fmt.Sprintf(logMsgMockSpecUnavailable, key, err), fmt.Sprintf(errMsgMockSpecUnavailable, key))
+		return
+	}
+
+	match, pathFound := findOperation(spec, r.Method, &url.URL{Path: requestPath})
+	if match == nil {
+		if pathFound {
+			s.writeJSON(w, r, http.StatusMethodNotAllowed, &proxyError{
+				Error:  fmt.Sprintf(errMsgMockMethodNotAllowed, requestPath, key, r.Method),
+				API:    key,
+				Method: r.Method,
+				Path:   requestPath,
+			})
+			return
+		}
+		s.writeJSON(w, r, http.StatusNotFound, &proxyError{
+			Error: fmt.Sprintf(errMsgMockPathNotFound, key, requestPath),
+			API:   key,
+			Path:  requestPath,
+		})
+		return
+	}
+
+	prefer := parsePrefer(r.Header.Values(headerPrefer))
+	if code := r.URL.Query().Get(queryParamMockCode); code != "" {
+		prefer.code = code
+	}
+	if example := r.URL.Query().Get(queryParamMockExample); example != "" {
+		prefer.example = example
+	}
+	status, response := selectMockResponse(spec, match.Operation, prefer.code)
+	if response == nil {
+		s.writeJSON(w, r, http.StatusBadRequest, &proxyError{
+			Error:  fmt.Sprintf(errMsgMockResponseUndefined, match.Method, match.Path, key, prefer.code),
+			API:    key,
+			Method: match.Method,
+			Path:   match.Path,
+		})
+		return
+	}
+
+	mediaType, body, hasBody := mockBody(spec, match.Operation, response, r.Header.Get(headerAccept), prefer.example)
+	for name, value := range mockHeaders(spec, response) {
+		w.Header().Set(name, value)
+	}
+	var applied []string
+	if prefer.code != "" {
+		applied = append(applied, "code="+prefer.code)
+	}
+	if prefer.example != "" {
+		applied = append(applied, "example="+prefer.example)
+	}
+	if len(applied) > 0 {
+		w.Header().Set(headerPreferenceApplied, strings.Join(applied, ", "))
+	}
+
+	if !hasBody || r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	encoded, err := encodeMockBody(mediaType, body)
+	if err != nil {
+		s.logAndSendError(w, r, http.StatusInternalServerError, fmt.Sprintf("%s: %v", errMsgMockEncodeFailed, err), errMsgMockEncodeFailed)
+		return
+	}
+	w.Header().Set(headerContentType, mediaType)
+	w.WriteHeader(status)
+	if _, err := w.Write(encoded); err != nil {
+		s.logger.Errorf("[%s %s] %s: %v", r.Method, r.URL.Path, errMsgFailedToWriteResponse, err)
+	}
+}
+
+// parsePrefer reads the code= and example= selectors of Prefer headers.
+func parsePrefer(values []string) mockPreferences {
+	var prefer mockPreferences
+	for _, value := range values {
+		for _, token := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ';' }) {
+			name, val, _ := strings.Cut(strings.TrimSpace(token), "=")
+			val = strings.Trim(strings.TrimSpace(val), `"`)
+			switch strings.ToLower(strings.TrimSpace(name)) {
+			case "code":
+				prefer.code = val
+			case "example":
+				prefer.example = val
+			}
+		}
+	}
+	return prefer
+}
+
+// selectMockResponse returns the status code and response object to mock. A requested code is
+// looked up like a real status (exact, range, then default); otherwise the lowest documented 2xx
+// response is used, then a 2XX range or default response as 200, then the lowest documented code.
+func selectMockResponse(spec, operation map[string]interface{}, code string) (int, map[string]interface{}) {
+	if code != "" {
+		status, err := strconv.Atoi(code)
+		if err != nil || status < 100 || status > 599 {
+			return 0, nil
+		}
+		return status, documentedResponse(spec, operation, status)
+	}
+
+	responses := asObject(operation["responses"])
+	var codes []int
+	for key := range responses {
+		if status, err := strconv.Atoi(key); err == nil {
+			codes = append(codes, status)
+		}
+	}
+	sort.Ints(codes)
+	for _, status := range codes {
+		if status >= 200 && status < 300 {
+			return status, documentedResponse(spec, operation, status)
+		}
+	}
+	for _, key := range []string{"2XX", "2xx", "default"} {
+		if response, ok := responses[key].(map[string]interface{}); ok {
+			return http.StatusOK, resolveRef(spec, response)
+		}
+	}
+	if len(codes) > 0 {
+		return codes[0], documentedResponse(spec, operation, codes[0])
+	}
+	return 0, nil
+}
+
+// mockBody returns the media type and value of a mocked response body, negotiated against the
+// Accept header. The value is a named or the first example if documented, otherwise synthesized
+// from the schema. The last return value is false if the response has no body.
+func mockBody(spec, operation, response map[string]interface{}, accept, exampleName string) (string, interface{}, bool) {
+	// OpenAPI 3: content by media type, each with a schema and examples
+	if content := asObject(response["content"]); len(content) > 0 {
+		mediaType := negotiateMediaType(sortedKeys(content), accept)
+		media := asObject(content[mediaType])
+		if example, ok := namedExample(spec, asObject(media["examples"]), exampleName); ok {
+			return mediaType, example, true
+		}
+		if example, ok := media["example"]; ok {
+			return mediaType, example, true
+		}
+		return mediaType, synthesizeValue(spec, asObject(media["schema"])), true
+	}
+
+	// Swagger 2.0: a schema, examples by media type, and produces on the operation or document
+	schema := asObject(response["schema"])
+	examples := asObject(response["examples"])
+	if schema == nil && len(examples) == 0 {
+		return "", nil, false
+	}
+	produces := stringList(operation["produces"])
+	if len(produces) == 0 {
+		produces = stringList(spec["produces"])
+	}
+	for mediaType := range examples {
+		if !containsString(produces, mediaType) {
+			produces = append(produces, mediaType)
+		}
+	}
+	if len(produces) == 0 {
+		produces = []string{contentTypeJSON}
+	}
+	sort.Strings(produces)
+	mediaType := negotiateMediaType(produces, accept)
+	if example, ok := examples[mediaType]; ok {
+		return mediaType, example, true
+	}
+	return mediaType, synthesizeValue(spec, schema), true
+}
+
+// namedExample returns the value of an OpenAPI 3 examples entry: the named one if present,
+// otherwise the first by name.
+func namedExample(spec, examples map[string]interface{}, name string) (interface{}, bool) {
+	if len(examples) == 0 {
+		return nil, false
+	}
+	if _, ok := examples[name]; !ok {
+		name = sortedKeys(examples)[0]
+	}
+	example := resolveRef(spec, asObject(examples[name]))
+	value, ok := example["value"]
+	return value, ok
+}
+
+// negotiateMediaType picks the media type of candidates that best matches an Accept header,
+// preferring JSON when the client accepts anything.
+func negotiateMediaType(candidates []string, accept string) string {
+	for _, accepted := range strings.Split(accept, ",") {
+		acceptedType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil || acceptedType == "*/*" {
+			continue
+		}
+		for _, candidate := range candidates {
+			if strings.EqualFold(candidate, acceptedType) ||
+				(strings.HasSuffix(acceptedType, "/*") && strings.HasPrefix(candidate, strings.TrimSuffix(acceptedType, "*"))) {
+				return candidate
+			}
+		}
+	}
+	for _, candidate := range candidates {
+		if isJSONMediaType(candidate) {
+			return candidate
+		}
+	}
+	return candidates[0]
+}
+
+// mockHeaders returns values for the documented headers of a response, from their examples or
+// synthesized from their schemas.
+func mockHeaders(spec, response map[string]interface{}) map[string]string {
+	headers := make(map[string]string)
+	documented := asObject(response["headers"])
+	for name := range documented {
+		if strings.EqualFold(name, headerContentType) {
+			continue
+		}
+		definition := resolveRef(spec, asObject(documented[name]))
+		value, ok := definition["example"]
+		if !ok {
+			schema, isSpec3 := definition["schema"].(map[string]interface{})
+			if !isSpec3 {
+				schema = definition // Swagger 2.0 headers are schemas themselves
+			}
+			value = synthesizeValue(spec, schema)
+		}
+		headers[name] = headerValue(value)
+	}
+	return headers
+}
+
+// headerValue formats a JSON value as a header value; arrays are comma-separated.
+func headerValue(value interface{}) string {
+	switch typed := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return typed
+	case []interface{}:
+		items := make([]string, len(typed))
+		for i, item := range typed {
+			items[i] = headerValue(item)
+		}
+		return strings.Join(items, ",")
+	}
+	return compactJSON(value)
+}
+
+// encodeMockBody encodes a mocked body for its media type: JSON for JSON media types, strings
+// as they are otherwise.
+func encodeMockBody(mediaType string, body interface{}) ([]byte, error) {
+	if text, ok := body.(string); ok && !isJSONMediaType(mediaType) {
+		return []byte(text), nil
+	}
+	return json.MarshalIndent(body, "", "  ")
+}
+
+// schemaSynthesizer builds sample values from the schemas of a document.
+type schemaSynthesizer struct {
+	spec      map[string]interface{}
+	expanding map[string]bool // $refs being synthesized, to end recursive schemas
+	remaining int             // Values left before arrays stop growing beyond one item
+}
+
+// synthesizeValue builds a value that matches schema: its example, default, const or first enum
+// value if any, otherwise a value of its type respecting common constraints.
+func synthesizeValue(spec, schema map[string]interface{}) interface{} {
+	g := &schemaSynthesizer{spec: spec, expanding: make(map[string]bool), remaining: mockMaxValues}
+	return g.value(schema, 0)
+}
+
+// value synthesizes a value at a nesting depth. A schema that refers back to one being
+// synthesized, or that is nested deeper than mockMaxDepth, yields null.
+func (g *schemaSynthesizer) value(schema map[string]interface{}, depth int) interface{} {
+	if ref, ok := schema["$ref"].(string); ok {
+		if g.expanding[ref] {
+			return nil
+		}
+		g.expanding[ref] = true
+		defer delete(g.expanding, ref)
+	}
+	schema = resolveRef(g.spec, schema)
+	if schema == nil || depth > mockMaxDepth {
+		return nil
+	}
+	g.remaining--
+	for _, key := range []string{"example", "default", "const"} {
+		if value, ok := schema[key]; ok {
+			return value
+		}
+	}
+	if examples, ok := schema["examples"].([]interface{}); ok && len(examples) > 0 {
+		return examples[0] // OpenAPI 3.1
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok && len(enum) > 0 {
+		return enum[0]
+	}
+	if allOf := schemaList(schema["allOf"]); len(allOf) > 0 {
+		merged := make(map[string]interface{})
+		var last interface{}
+		for _, sub := range allOf {
+			last = g.value(sub, depth+1)
+			for k, v := range asObject(last) {
+				merged[k] = v
+			}
+		}
+		for k, v := range g.object(schema, depth) {
+			merged[k] = v
+		}
+		if len(merged) == 0 {
+			return last
+		}
+		return merged
+	}
+	for _, key := range []string{"oneOf", "anyOf"} {
+		if alternatives := schemaList(schema[key]); len(alternatives) > 0 {
+			return g.value(alternatives[0], depth+1)
+		}
+	}
+
+	schemaType := ""
+	for _, t := range schemaTypes(schema) {
+		if t != "null" {
+			schemaType = t
+			break
+		}
+	}
+	if schemaType == "" {
+		switch {
+		case schema["properties"] != nil || schema["additionalProperties"] != nil:
+			schemaType = "object"
+		case schema["items"] != nil:
+			schemaType = "array"
+		}
+	}
+
+	switch schemaType {
+	case "object":
+		return g.object(schema, depth)
+	case "array":
+		item := g.value(asObject(schema["items"]), depth+1)
+		if item == nil {
+			return []interface{}{} // Recursive items
+		}
+		// Nested arrays multiply their sizes, so the number of items is capped both per array
+		// and by the values synthesized so far
+		count := 1
+		if minItems, ok := schema["minItems"].(float64); ok && minItems > 1 {
+			count = int(math.Min(minItems, mockMaxArrayItems))
+		}
+		items := []interface{}{item}
+		for len(items) < count && g.remaining > 0 {
+			items = append(items, g.value(asObject(schema["items"]), depth+1))
+		}
+		return items
+	case "string":
+		return synthesizeString(schema)
+	case "integer", "number":
+		return synthesizeNumber(schema, schemaType == "integer")
+	case "boolean":
+		return true
+	}
+	return nil
+}
+
+// object builds an object with a value for every property except writeOnly ones, or a sample
+// entry if the schema only describes additionalProperties. Optional properties that can't be
+// synthesized (such as recursive ones) are left out.
+func (g *schemaSynthesizer) object(schema map[string]interface{}, depth int) map[string]interface{} {
+	object := make(map[string]interface{})
+	properties := asObject(schema["properties"])
+	required := stringList(schema["required"])
+	for _, name := range sortedKeys(properties) {
+		property := resolveRef(g.spec, asObject(properties[name]))
+		if writeOnly, _ := property["writeOnly"].(bool); writeOnly {
+			continue
+		}
+		value := g.value(asObject(properties[name]), depth+1)
+		if value == nil && !containsString(required, name) {
+			continue
+		}
+		object[name] = value
+	}
+	if additional, ok := schema["additionalProperties"].(map[string]interface{}); ok && len(properties) == 0 {
+		object["additionalProp1"] = g.value(additional, depth+1)
+	}
+	return object
+}
+
+// synthesizeString returns a sample string for the format and length constraints of a schema.
+func synthesizeString(schema map[string]interface{}) string {
+	format, _ := schema["format"].(string)
+	value, ok := mockFormatValues[format]
+	if !ok {
+		value = "string"
+	}
+	if minLength, ok := schema["minLength"].(float64); ok && len(value) < int(minLength) {
+		value += strings.Repeat("x", int(minLength)-len(value))
+	}
+	if maxLength, ok := schema["maxLength"].(float64); ok && len(value) > int(maxLength) {
+		value = value[:int(maxLength)]
+	}
+	return value
+}
+
+// synthesizeNumber returns the smallest value allowed by the bounds of a schema, or 0.
+func synthesizeNumber(schema map[string]interface{}, integer bool) float64 {
+	value := 0.0
+	if minimum, ok := schema["minimum"].(float64); ok {
+		value = minimum
+		if exclusive, _ := schema["exclusiveMinimum"].(bool); exclusive {
+			value++
+		}
+	} else if minimum, ok := schema["exclusiveMinimum"].(float64); ok {
+		value = minimum + 1
+	}
+	if maximum, ok := schema["maximum"].(float64); ok && value > maximum {
+		value = maximum
+	}
+	if integer {
+		value = math.Ceil(value)
+	}
+	return value
+}
+
+// stringList returns the strings of a JSON array.
+func stringList(value interface{}) []string {
+	items, _ := value.([]interface{})
+	var list []string
+	for _, item := range items {
+		if str, ok := item.(string); ok {
+			list = append(list, str)
+		}
+	}
+	return list
+}
+
+// tryItOutTarget returns where "Try it out" requests of a served spec go: the "target" query
+// parameter of the spec request if valid, otherwise the API's tryItOut setting, otherwise
+// TRY_IT_OUT_TARGET. Documentation-only APIs (such as the Kubernetes API) can't be proxied, so
+// they keep their own servers unless the mock is chosen.
+func (s *Server) tryItOutTarget(r *http.Request, metadata APIMetadata) string {
+	for _, target := range []string{r.URL.Query().Get(queryParamTarget), metadata.TryItOut, s.tryItOutDefault} {
+		if target == tryItOutTargetMock || target == tryItOutTargetProxy {
+			return target
+		}
+	}
+	return tryItOutTargetProxy
+}
+
+// mockRoutePath returns the mock route of an API, relative to the server's base path.
+func mockRoutePath(key string) string {
+	return httpPathMock + url.PathEscape(key)
+}
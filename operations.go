@@ -0,0 +1,233 @@
+package swagger
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Constants for request-time spec lookups
+const (
+	specCacheTTL     = time.Minute      // How long a fetched document is reused for lookups such as operation matching in the proxy
+	specFetchTimeout = 10 * time.Second // Bounds fetching a document, so lookups don't hang on a slow spec endpoint
+)
+
+// cachedSpec is a fetched API document and the time it was fetched.
+type cachedSpec struct {
+	spec    map[string]interface{}
+	fetched time.Time
+}
+
+// specFetch is a fetch of a document in progress. Concurrent lookups of the same API wait for it
+// instead of fetching the document again.
+type specFetch struct {
+	done chan struct{} // Closed when spec and err are set
+	spec map[string]interface{}
+	err  error
+}
+
+// specCache keeps recently fetched API documents by API key. Cached documents are shared
+// and must be treated as read-only.
+type specCache struct {
+	mux      sync.Mutex
+	entries  map[string]cachedSpec
+	inflight map[string]*specFetch
+}
+
+// operationMatch is the operation of an API document that a request corresponds to.
+type operationMatch struct {
+	Path       string                 // Path template as written in the document, e.g. /pets/{petId}
+	Method     string                 // Upper-case HTTP method
+	PathItem   map[string]interface{} // Path item containing the operation
+	Operation  map[string]interface{} // Operation object
+	PathParams map[string]string      // Values of the path template parameters
+}
+
+// cachedSpecFor returns the document of a registered API, fetching it if it is not cached
+// or older than specCacheTTL. The result is shared and must not be modified.
+func (s *Server) cachedSpecFor(key string) (map[string]interface{}, error) {
+	s.specCache.mux.Lock()
+	entry, ok := s.specCache.entries[key]
+	s.specCache.mux.Unlock()
+	if ok && time.Since(entry.fetched) < specCacheTTL {
+		return entry.spec, nil
+	}
+
+	s.specsMux.RLock()
+	metadata, exists := s.specs[key]
+	s.specsMux.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("%s: %s", errMsgAPINotFound, key)
+	}
+
+	s.specCache.mux.Lock()
+	if fetch, ok := s.specCache.inflight[key]; ok {
+		s.specCache.mux.Unlock()
+		<-fetch.done
+		return fetch.spec, fetch.err
+	}
+	fetch := &specFetch{done: make(chan struct{})}
+	s.specCache.inflight[key] = fetch
+	s.specCache.mux.Unlock()
+
+	fetch.spec, _, fetch.err = s.fetchSpec(metadata)
+	if fetch.err == nil {
+		s.storeCachedSpec(key, fetch.spec)
+	}
+	s.specCache.mux.Lock()
+	delete(s.specCache.inflight, key)
+	s.specCache.mux.Unlock()
+	close(fetch.done)
+	return fetch.spec, fetch.err
+}
+
+// storeCachedSpec caches a freshly fetched document. A shallow copy is stored, so callers
+// may still replace top-level fields (such as servers) of their own copy.
+func (s *Server) storeCachedSpec(key string, spec map[string]interface{}) {
+	stored := make(map[string]interface{}, len(spec))
+	for k, v := range spec {
+		stored[k] = v
+	}
+	s.specCache.mux.Lock()
+	defer s.specCache.mux.Unlock()
+	s.specCache.entries[key] = cachedSpec{spec: stored, fetched: time.Now()}
+}
+
+// findOperation returns the operation of spec that a request with the given method and target
+// URL corresponds to. The base paths of the document's servers are stripped from the target
+// path before matching it against the path templates; literal segments take precedence over
+// templated ones. The second return value reports whether the path exists at all, so that
+// callers can tell an undocumented path from an undocumented method.
+func findOperation(spec map[string]interface{}, method string, target *url.URL) (*operationMatch, bool) {
+	paths, _ := spec["paths"].(map[string]interface{})
+	method = strings.ToLower(method)
+
+	var best *operationMatch
+	bestScore, pathFound := -1, false
+	for _, basePath := range specBasePaths(spec) {
+		requestPath := target.EscapedPath() // Segments are unescaped one by one, so %2F stays within its segment
+		if basePath != "" {
+			if requestPath != basePath && !strings.HasPrefix(requestPath, basePath+"/") {
+				continue
+			}
+			requestPath = strings.TrimPrefix(requestPath, basePath)
+		}
+		for template, item := range paths {
+			params, score, ok := matchPathTemplate(template, requestPath)
+			if !ok {
+				continue
+			}
+			pathFound = true
+			pathItem, _ := item.(map[string]interface{})
+			operation, ok := pathItem[method].(map[string]interface{})
+			if !ok || score <= bestScore {
+				continue
+			}
+			best = &operationMatch{
+				Path:       template,
+				Method:     strings.ToUpper(method),
+				PathItem:   pathItem,
+				Operation:  operation,
+				PathParams: params,
+			}
+			bestScore = score
+		}
+	}
+	return best, pathFound
+}
+
+// specBasePaths returns the candidate base paths of a document's servers, longest first,
+// always ending with the empty base path.
+func specBasePaths(spec map[string]interface{}) []string {
+	seen := map[string]bool{"": true}
+	var basePaths []string
+	for _, server := range specServerURLs(spec) {
+		serverURL, err := url.Parse(server)
+		if err != nil {
+			continue
+		}
+		basePath := strings.TrimSuffix(serverURL.Path, "/")
+		if !seen[basePath] && !strings.Contains(basePath, "{") {
+			seen[basePath] = true
+			basePaths = append(basePaths, basePath)
+		}
+	}
+	if basePath, ok := spec["basePath"].(string); ok {
+		basePath = strings.TrimSuffix(basePath, "/")
+		if !seen[basePath] {
+			seen[basePath] = true
+			basePaths = append(basePaths, basePath)
+		}
+	}
+	// Longest first, so that the most specific server wins
+	for i := 1; i < len(basePaths); i++ {
+		for j := i; j > 0 && len(basePaths[j]) > len(basePaths[j-1]); j-- {
+			basePaths[j], basePaths[j-1] = basePaths[j-1], basePaths[j]
+		}
+	}
+	return append(basePaths, "")
+}
+
+// templateParamPattern matches a {name} parameter inside a path template segment.
+var templateParamPattern = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// matchPathTemplate matches a request path against a path template such as /pets/{petId}.
+// It returns the parameter values and a score that counts literal segments, so that
+// /pets/mine is preferred over /pets/{petId} for the request /pets/mine.
+func matchPathTemplate(template, requestPath string) (map[string]string, int, bool) {
+	templateSegments := strings.Split(strings.Trim(template, "/"), "/")
+	requestSegments := strings.Split(strings.Trim(requestPath, "/"), "/")
+	if len(templateSegments) != len(requestSegments) {
+		return nil, 0, false
+	}
+
+	params := make(map[string]string)
+	score := 0
+	for i, templateSegment := range templateSegments {
+		requestSegment, err := url.PathUnescape(requestSegments[i])
+		if err != nil {
+			requestSegment = requestSegments[i]
+		}
+		if !strings.Contains(templateSegment, "{") {
+			if templateSegment != requestSegment {
+				return nil, 0, false
+			}
+			score++
+			continue
+		}
+
+		// Segments may mix literals and parameters, e.g. {name}.{format}
+		var pattern strings.Builder
+		var names []string
+		pattern.WriteString("^")
+		last := 0
+		for _, loc := range templateParamPattern.FindAllStringSubmatchIndex(templateSegment, -1) {
+			pattern.WriteString(regexp.QuoteMeta(templateSegment[last:loc[0]]))
+			pattern.WriteString("(.+?)")
+			names = append(names, templateSegment[loc[2]:loc[3]])
+			last = loc[1]
+		}
+		pattern.WriteString(regexp.QuoteMeta(templateSegment[last:]) + "$")
+		values := regexp.MustCompile(pattern.String()).FindStringSubmatch(requestSegment)
+		if values == nil {
+			return nil, 0, false
+		}
+		for j, name := range names {
+			params[name] = values[j+1]
+		}
+	}
+	return params, score, true
+}
+
+// isMethodAllowed reports whether method is listed in allowed (case-insensitively).
+func isMethodAllowed(allowed []string, method string) bool {
+	for _, allowedMethod := range allowed {
+		if strings.EqualFold(allowedMethod, method) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package swagger
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindOperation(t *testing.T) {
+	openAPI := map[string]interface{}{}
+	mustUnmarshal(t, `{
+		"openapi": "3.0.3",
+		"servers": [{"url": "https://api.example.com/v1"}, {"url": "https://api.example.com/v1/beta"}],
+		"paths": {
+			"/pets": {"get": {}, "post": {}},
+			"/pets/{petId}": {"get": {}, "delete": {}},
+			"/pets/mine": {"get": {}},
+			"/pets/{petId}/photos/{file}.{ext}": {"get": {}},
+			"/preview": {"get": {}}
+		}
+	}`, &openAPI)
+	swagger2 := map[string]interface{}{}
+	mustUnmarshal(t, `{
+		"swagger": "2.0",
+		"basePath": "/api/",
+		"paths": {"/users/{id}": {"put": {}}}
+	}`, &swagger2)
+
+	tests := []struct {
+		name          string
+		spec          map[string]interface{}
+		method        string
+		target        string
+		wantPath      string
+		wantParams    map[string]string
+		wantPathFound bool
+	}{
+		{name: "server base path stripped", spec: openAPI, method: "GET", target: "https://api.example.com/v1/pets",
+			wantPath: "/pets", wantParams: map[string]string{}, wantPathFound: true},
+		{name: "path without base path", spec: openAPI, method: "post", target: "http://localhost:8080/pets",
+			wantPath: "/pets", wantParams: map[string]string{}, wantPathFound: true},
+		{name: "templated segment", spec: openAPI, method: "DELETE", target: "https://api.example.com/v1/pets/42",
+			wantPath: "/pets/{petId}", wantParams: map[string]string{"petId": "42"}, wantPathFound: true},
+		{name: "escaped parameter value", spec: openAPI, method: "GET", target: "https://api.example.com/v1/pets/a%2Fb",
+			wantPath: "/pets/{petId}", wantParams: map[string]string{"petId": "a/b"}, wantPathFound: true},
+		{name: "literal segment preferred", spec: openAPI, method: "GET", target: "https://api.example.com/v1/pets/mine",
+			wantPath: "/pets/mine", wantParams: map[string]string{}, wantPathFound: true},
+		{name: "templated fallback for other methods", spec: openAPI, method: "DELETE", target: "https://api.example.com/v1/pets/mine",
+			wantPath: "/pets/{petId}", wantParams: map[string]string{"petId": "mine"}, wantPathFound: true},
+		{name: "several parameters in one segment", spec: openAPI, method: "GET", target: "https://api.example.com/v1/pets/1/photos/cat.png",
+			wantPath: "/pets/{petId}/photos/{file}.{ext}", wantParams: map[string]string{"petId": "1", "file": "cat", "ext": "png"}, wantPathFound: true},
+		{name: "longest base path", spec: openAPI, method: "GET", target: "https://api.example.com/v1/beta/preview",
+			wantPath: "/preview", wantParams: map[string]string{}, wantPathFound: true},
+		{name: "undocumented method", spec: openAPI, method: "PUT", target: "https://api.example.com/v1/pets/42",
+			wantPathFound: true},
+		{name: "undocumented path", spec: openAPI, method: "GET", target: "https://api.example.com/v1/owners",
+			wantPathFound: false},
+		{name: "base path is a whole segment", spec: openAPI, method: "GET", target: "https://api.example.com/v1x/pets",
+			wantPathFound: false},
+		{name: "Swagger 2.0 basePath", spec: swagger2, method: "PUT", target: "http://users.svc/api/users/7",
+			wantPath: "/users/{id}", wantParams: map[string]string{"id": "7"}, wantPathFound: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, pathFound := findOperation(tt.spec, tt.method, mustParseURL(t, tt.target))
+			if pathFound != tt.wantPathFound {
+				t.Errorf("findOperation(%s %s) path found = %v, want %v", tt.method, tt.target, pathFound, tt.wantPathFound)
+			}
+			if tt.wantPath == "" {
+				if match != nil {
+					t.Errorf("findOperation(%s %s) = %s, want no operation", tt.method, tt.target, match.Path)
+				}
+				return
+			}
+			if match == nil {
+				t.Fatalf("findOperation(%s %s) = nil, want %s", tt.method, tt.target, tt.wantPath)
+			}
+			if match.Path != tt.wantPath || !reflect.DeepEqual(match.PathParams, tt.wantParams) {
+				t.Errorf("findOperation(%s %s) = %s %v, want %s %v", tt.method, tt.target, match.Path, match.PathParams, tt.wantPath, tt.wantParams)
+			}
+		})
+	}
+}
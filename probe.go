@@ -0,0 +1,122 @@
+package swagger
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Constants for auto-probing well-known spec locations
+const (
+	probeCacheTTL       = 5 * time.Minute // How long a discovered spec location is reused before probing again
+	probeMissCacheTTL   = time.Minute     // How long a base URL without a spec is skipped before probing again
+	serviceBaseURLFmt   = "http://%s.%s.svc"
+	resourceTypeService = "Service"
+
+	logMsgProbingSpec      = "Auto-probing spec locations for %s at %s"
+	logMsgProbeFound       = "Auto-probe found spec for %s at %s"
+	errMsgProbeNoBaseURL   = "autoProbe requires a base URL or a Service resourceName and namespace"
+	errMsgProbeNoSpecFound = "no OpenAPI document found at any well-known path under %s"
+)
+
+// wellKnownSpecPaths are the default OpenAPI document locations of common frameworks,
+// in the order they are probed.
+var wellKnownSpecPaths = []string{
+	"/v3/api-docs",             // springdoc-openapi
+	"/v2/api-docs",             // Springfox
+	"/openapi.json",            // FastAPI
+	"/swagger/v1/swagger.json", // ASP.NET Core (Swashbuckle)
+	"/api-json",                // NestJS
+	"/swagger.json",            // Generic, go-swagger
+	"/swagger/doc.json",        // swaggo
+	"/q/openapi?format=json",   // Quarkus
+	"/api-docs",                // Express (swagger-jsdoc), Springfox legacy
+	"/openapi",                 // Micronaut, Helidon
+}
+
+// probeResult is a cached auto-probe outcome for a base URL.
+type probeResult struct {
+	specURL string // Empty if no spec was found
+	expires time.Time
+}
+
+// probeCache remembers discovered spec locations so that probing does not run on every update.
+type probeCache struct {
+	mux     sync.Mutex
+	results map[string]probeResult // Keyed by cluster and base URL
+}
+
+// probeSpec sets api.URL to the discovered spec location, or api.Error if none is found.
+func (s *Server) probeSpec(ctx context.Context, api *APIMetadata) {
+	baseURL := strings.TrimSuffix(api.URL, "/")
+	if baseURL == "" && api.ResourceType == resourceTypeService && api.ResourceName != "" && api.Namespace != "" {
+		baseURL = fmt.Sprintf(serviceBaseURLFmt, api.ResourceName, api.Namespace)
+	}
+	if baseURL == "" {
+		api.Error = errMsgProbeNoBaseURL
+		return
+	}
+
+	cacheKey := api.Cluster + "|" + baseURL
+	s.probes.mux.Lock()
+	cached, ok := s.probes.results[cacheKey]
+	s.probes.mux.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		if cached.specURL == "" {
+			api.Error = fmt.Sprintf(errMsgProbeNoSpecFound, baseURL)
+			return
+		}
+		api.URL = cached.specURL
+		return
+	}
+
+	s.logger.Debugf(logMsgProbingSpec, api.Name, baseURL)
+	for _, path := range wellKnownSpecPaths {
+		if ctx.Err() != nil {
+			api.Error = ctx.Err().Error()
+			return
+		}
+		candidate := baseURL + path
+		if !s.isSpecDocument(ctx, api.Cluster, candidate) {
+			continue
+		}
+		s.logger.Infof(logMsgProbeFound, api.Name, candidate)
+		s.probes.mux.Lock()
+		s.probes.results[cacheKey] = probeResult{specURL: candidate, expires: time.Now().Add(probeCacheTTL)}
+		s.probes.mux.Unlock()
+		api.URL = candidate
+		return
+	}
+	if ctx.Err() != nil {
+		api.Error = ctx.Err().Error()
+		return // Not a miss: the last candidates were canceled
+	}
+	// Remember the miss for a while: probing every candidate path takes up to
+	// len(wellKnownSpecPaths) * discoveryTimeout, on every watch interval otherwise
+	s.probes.mux.Lock()
+	s.probes.results[cacheKey] = probeResult{expires: time.Now().Add(probeMissCacheTTL)}
+	s.probes.mux.Unlock()
+	api.Error = fmt.Sprintf(errMsgProbeNoSpecFound, baseURL)
+}
+
+// isSpecDocument reports whether rawURL serves a JSON OpenAPI 3.x or Swagger 2.0 document.
+func (s *Server) isSpecDocument(ctx context.Context, cluster string, rawURL string) bool {
+	var doc map[string]interface{}
+	if err := s.fetchJSON(ctx, cluster, rawURL, &doc); err != nil {
+		return false
+	}
+	return isOpenAPIDocument(doc)
+}
+
+// isOpenAPIDocument reports whether doc looks like an OpenAPI 3.x or Swagger 2.0 document.
+func isOpenAPIDocument(doc map[string]interface{}) bool {
+	openAPIVersion, _ := doc["openapi"].(string)
+	swaggerVersion, _ := doc["swagger"].(string)
+	if !strings.HasPrefix(openAPIVersion, "3.") && swaggerVersion != "2.0" {
+		return false
+	}
+	_, hasInfo := doc["info"].(map[string]interface{})
+	return hasInfo
+}
@@ -0,0 +1,31 @@
+package swagger
+
+import "testing"
+
+func TestIsOpenAPIDocument(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want bool
+	}{
+		{name: "OpenAPI 3.0", doc: `{"openapi": "3.0.3", "info": {"title": "Pets"}, "paths": {}}`, want: true},
+		{name: "OpenAPI 3.1", doc: `{"openapi": "3.1.0", "info": {"title": "Pets"}}`, want: true},
+		{name: "Swagger 2.0", doc: `{"swagger": "2.0", "info": {"title": "Pets"}, "paths": {}}`, want: true},
+		{name: "missing info", doc: `{"openapi": "3.0.0", "paths": {}}`, want: false},
+		{name: "info not an object", doc: `{"openapi": "3.0.0", "info": "Pets"}`, want: false},
+		{name: "unsupported Swagger version", doc: `{"swagger": "1.2", "info": {}}`, want: false},
+		{name: "future OpenAPI version", doc: `{"openapi": "4.0.0", "info": {}}`, want: false},
+		{name: "numeric version", doc: `{"openapi": 3.0, "info": {}}`, want: false},
+		{name: "swagger-config", doc: `{"configUrl": "/v3/api-docs/swagger-config", "urls": []}`, want: false},
+		{name: "empty object", doc: `{}`, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var doc map[string]interface{}
+			mustUnmarshal(t, tt.doc, &doc)
+			if got := isOpenAPIDocument(doc); got != tt.want {
+				t.Errorf("isOpenAPIDocument(%s) = %v, want %v", tt.doc, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,360 @@
+package swagger
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Constants for proxy target allowlisting
+const (
+	envVarProxyAllowedTargets = "PROXY_ALLOWED_TARGETS" // Comma-separated host glob patterns the proxy may reach in addition to registered APIs
+	envVarProxyAllowedCIDRs   = "PROXY_ALLOWED_CIDRS"   // Comma-separated CIDRs exempt from the address range checks
+	envVarProxyEnforceOps     = "PROXY_ENFORCE_OPERATIONS"
+
+	logMsgProxyDenied        = "Proxy request denied from %s to %s: %s"
+	logMsgInvalidAllowedCIDR = "Ignoring invalid %s entry %q: %v"
+	errMsgProxyTargetDenied  = "Proxy target is not allowed"
+
+	errMsgProxyMethodNotAllowed   = "Method %s is not allowed for API %s"
+	errMsgProxyOperationNotInSpec = "%s %s is not an operation of API %s"
+	errMsgProxySpecUnavailable    = "Cannot verify the operation: spec of API %s is unavailable"
+)
+
+// errProxyAddressDenied is returned by the guarded proxy clients when a target resolves to a
+// denied address. It is matched with errors.Is to tell denials apart from upstream failures.
+var errProxyAddressDenied = errors.New("proxy address denied")
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598), treated like private ranges.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// proxyTargetClass describes how far a proxy target is trusted.
+type proxyTargetClass int
+
+const (
+	proxyTargetDenied      proxyTargetClass = iota // Not a registered API and not allowlisted
+	proxyTargetRegistered                          // A server of a registered API; may resolve to private addresses
+	proxyTargetAllowlisted                         // Matches PROXY_ALLOWED_TARGETS; must resolve to public addresses
+)
+
+// proxyGuard decides which targets the proxy may reach. Targets must be a server of a registered
+// API (the origin of its spec URL, or a server declared in its served spec) or match an allowlist
+// pattern. Redirects are returned to the browser, whose next request is checked again, and
+// resolved addresses are checked at dial time so that DNS cannot point an allowed name at a
+// forbidden address.
+type proxyGuard struct {
+	allowedTargets []string       // Host glob patterns, e.g. "*.example.com" or "api.example.com:8443"
+	allowedCIDRs   []netip.Prefix // Address ranges exempt from the range checks
+	timeout        time.Duration  // Upstream response header timeout of the guarded clients
+
+	mux     sync.RWMutex
+	apis    map[string]APIMetadata // Registered APIs by key
+	servers map[string][]*url.URL  // Server base URLs learned from served specs, by API key
+
+	registeredClient  *http.Client // For registered targets: private ranges allowed
+	allowlistedClient *http.Client // For allowlisted targets: public addresses only
+}
+
+// newProxyGuard creates a proxyGuard configured from PROXY_ALLOWED_TARGETS and PROXY_ALLOWED_CIDRS.
+func (s *Server) newProxyGuard() *proxyGuard {
+	g := &proxyGuard{
+		allowedTargets: getEnvList(envVarProxyAllowedTargets),
+		timeout:        s.limits.timeout,
+		apis:           make(map[string]APIMetadata),
+		servers:        make(map[string][]*url.URL),
+	}
+	for _, cidr := range getEnvList(envVarProxyAllowedCIDRs) {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			s.logger.Warnf(logMsgInvalidAllowedCIDR, envVarProxyAllowedCIDRs, cidr, err)
+			continue
+		}
+		g.allowedCIDRs = append(g.allowedCIDRs, prefix)
+	}
+	g.registeredClient = g.newClient(proxyTargetRegistered)
+	g.allowlistedClient = g.newClient(proxyTargetAllowlisted)
+	return g
+}
+
+// newClient creates an HTTP client whose dialer rejects addresses not permitted for class.
+// Redirects are not followed but returned to the caller, whose next request is checked again.
+func (g *proxyGuard) newClient(class proxyTargetClass) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if reason := g.addressDenied(addrPort.Addr(), class == proxyTargetRegistered); reason != "" {
+				return fmt.Errorf("%w: %s is %s", errProxyAddressDenied, address, reason)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Transport: newProxyTransport(dialer.DialContext, g.timeout),
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// clientFor returns the guarded client for a target class.
+func (g *proxyGuard) clientFor(class proxyTargetClass) *http.Client {
+	if class == proxyTargetRegistered {
+		return g.registeredClient
+	}
+	return g.allowlistedClient
+}
+
+// setAPIs replaces the registered APIs and forgets learned servers of APIs that are gone.
+func (g *proxyGuard) setAPIs(apis map[string]APIMetadata) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	g.apis = apis
+	for key := range g.servers {
+		if _, exists := apis[key]; !exists {
+			delete(g.servers, key)
+		}
+	}
+}
+
+// learnServers records the absolute server URLs declared in an API's served spec. Only servers
+// on a host the API may reach (see serverHostAllowed) are recorded: registered targets may reach
+// private addresses and get the API's credentials, so a spec must not be able to add hosts.
+func (g *proxyGuard) learnServers(key string, spec map[string]interface{}) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	api, exists := g.apis[key]
+	if !exists {
+		return
+	}
+
+	var servers []*url.URL
+	for _, server := range specServerURLs(spec) {
+		serverURL, err := url.Parse(server)
+		if err != nil || !serverURL.IsAbs() || strings.Contains(server, "{") {
+			continue // Relative or templated servers can't be matched reliably
+		}
+		if !serverHostAllowed(api, serverURL) {
+			continue
+		}
+		servers = append(servers, serverURL)
+	}
+	g.servers[key] = servers
+}
+
+// serverHostAllowed reports whether a server declared in an API's spec is on a host the API may
+// reach: the host of its spec URL or BaseURL, or one matching its AllowedHosts patterns.
+func serverHostAllowed(api APIMetadata, server *url.URL) bool {
+	for _, rawURL := range []string{api.URL, api.BaseURL} {
+		if known, err := url.Parse(rawURL); err == nil && known.IsAbs() && strings.EqualFold(known.Hostname(), server.Hostname()) {
+			return true
+		}
+	}
+	for _, pattern := range api.AllowedHosts {
+		pattern = strings.ToLower(pattern)
+		if matched, _ := path.Match(pattern, strings.ToLower(server.Host)); matched {
+			return true
+		}
+		if matched, _ := path.Match(pattern, strings.ToLower(server.Hostname())); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAPI returns the key of the registered API in cluster whose servers contain target.
+// The API with the longest matching server path wins. APIs whose document comes from a Fetch
+// function (such as the Kubernetes API groups) are documentation-only and never match.
+func (g *proxyGuard) matchAPI(cluster string, target *url.URL) (string, bool) {
+	g.mux.RLock()
+	defer g.mux.RUnlock()
+
+	matchedKey, matchedLen := "", -1
+	for key, api := range g.apis {
+		if api.Cluster != cluster || api.Fetch != nil {
+			continue
+		}
+		bases := g.servers[key]
+		if origin, err := url.Parse(api.URL); err == nil && origin.IsAbs() {
+			bases = append([]*url.URL{{Scheme: origin.Scheme, Host: origin.Host}}, bases...)
+		}
+		if baseURL, err := url.Parse(api.BaseURL); err == nil && baseURL.IsAbs() {
+			bases = append(bases, baseURL)
+		}
+		for _, base := range bases {
+			if baseLen, ok := matchServer(base, target); ok && baseLen > matchedLen {
+				matchedKey, matchedLen = key, baseLen
+			}
+		}
+	}
+	return matchedKey, matchedLen >= 0
+}
+
+// classify returns how far target is trusted, the key of the registered API it belongs to
+// (if any), and the reason if it is denied.
+func (g *proxyGuard) classify(cluster string, target *url.URL) (proxyTargetClass, string, string) {
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return proxyTargetDenied, "", fmt.Sprintf("scheme %q is not allowed", target.Scheme)
+	}
+	if key, ok := g.matchAPI(cluster, target); ok {
+		return proxyTargetRegistered, key, ""
+	}
+	for _, pattern := range g.allowedTargets {
+		if matched, _ := path.Match(strings.ToLower(pattern), strings.ToLower(target.Host)); matched {
+			return proxyTargetAllowlisted, "", ""
+		}
+		if matched, _ := path.Match(strings.ToLower(pattern), strings.ToLower(target.Hostname())); matched {
+			return proxyTargetAllowlisted, "", ""
+		}
+	}
+	return proxyTargetDenied, "", "target does not belong to a registered API and matches no allowlist pattern"
+}
+
+// addressDenied returns why a resolved address may not be dialled, or "" if it may.
+// Loopback, link-local (including cloud metadata endpoints), unspecified and multicast addresses
+// are always denied; private and shared ranges only when allowPrivate is false. Addresses in
+// PROXY_ALLOWED_CIDRS are always permitted.
+func (g *proxyGuard) addressDenied(addr netip.Addr, allowPrivate bool) string {
+	addr = addr.Unmap()
+	for _, prefix := range g.allowedCIDRs {
+		if prefix.Contains(addr) {
+			return ""
+		}
+	}
+	switch {
+	case addr.IsLoopback():
+		return "a loopback address"
+	case addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast():
+		return "a link-local address"
+	case addr.IsUnspecified():
+		return "an unspecified address"
+	case addr.IsMulticast():
+		return "a multicast address"
+	case !allowPrivate && (addr.IsPrivate() || sharedAddressSpace.Contains(addr)):
+		return "a private address"
+	}
+	return ""
+}
+
+// matchServer reports whether target lies under the server base URL, and the length of the
+// matched base path.
+func matchServer(base, target *url.URL) (int, bool) {
+	if !strings.EqualFold(base.Scheme, target.Scheme) || !strings.EqualFold(hostWithPort(base), hostWithPort(target)) {
+		return 0, false
+	}
+	basePath := strings.TrimSuffix(base.Path, "/")
+	if basePath != "" && target.Path != basePath && !strings.HasPrefix(target.Path, basePath+"/") {
+		return 0, false
+	}
+	return len(basePath), true
+}
+
+// hostWithPort returns the host of u with the scheme's default port made explicit.
+func hostWithPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if strings.EqualFold(u.Scheme, "https") {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
+
+// specServerURLs returns the server URLs declared in an OpenAPI 3.x (servers) or
+// Swagger 2.0 (schemes, host, basePath) document.
+func specServerURLs(spec map[string]interface{}) []string {
+	var urls []string
+	if servers, ok := spec["servers"].([]interface{}); ok {
+		for _, server := range servers {
+			if serverMap, ok := server.(map[string]interface{}); ok {
+				if serverURL, ok := serverMap["url"].(string); ok {
+					urls = append(urls, serverURL)
+				}
+			}
+		}
+	}
+	if host, ok := spec["host"].(string); ok && host != "" {
+		basePath, _ := spec["basePath"].(string)
+		schemes, _ := spec["schemes"].([]interface{})
+		if len(schemes) == 0 {
+			schemes = []interface{}{"http", "https"}
+		}
+		for _, scheme := range schemes {
+			if schemeStr, ok := scheme.(string); ok {
+				urls = append(urls, schemeStr+"://"+host+basePath)
+			}
+		}
+	}
+	return urls
+}
+
+// proxyError is the JSON body of a request the proxy refuses to forward.
+type proxyError struct {
+	Error          string            `json:"error"`
+	API            string            `json:"api,omitempty"`
+	Method         string            `json:"method,omitempty"`
+	Path           string            `json:"path,omitempty"`
+	AllowedMethods []string          `json:"allowedMethods,omitempty"`
+	Violations     []schemaViolation `json:"violations,omitempty"` // Why the request doesn't match its operation
+}
+
+// authorizeOperation checks a proxied request against the registered API it targets: the method
+// must be listed in the API's AllowedMethods and, with PROXY_ENFORCE_OPERATIONS, the method and
+// path must be an operation of the API's spec. It returns the violation, or nil if the request
+// may be forwarded.
+func (s *Server) authorizeOperation(key string, method string, target *url.URL) *proxyError {
+	s.specsMux.RLock()
+	metadata, exists := s.specs[key]
+	s.specsMux.RUnlock()
+	if !exists {
+		return &proxyError{Error: errMsgAPINotFound, API: key}
+	}
+
+	if !isMethodAllowed(metadata.AllowedMethods, method) {
+		return &proxyError{
+			Error:          fmt.Sprintf(errMsgProxyMethodNotAllowed, method, key),
+			API:            key,
+			Method:         method,
+			AllowedMethods: metadata.AllowedMethods,
+		}
+	}
+	if !s.enforceOperations {
+		return nil
+	}
+
+	spec, err := s.cachedSpecFor(key)
+	if err != nil {
+		s.logger.Warnf("Failed to load spec of %s to verify proxied operation: %v", key, err)
+		return &proxyError{Error: fmt.Sprintf(errMsgProxySpecUnavailable, key), API: key}
+	}
+	if match, _ := findOperation(spec, method, target); match == nil {
+		return &proxyError{
+			Error:  fmt.Sprintf(errMsgProxyOperationNotInSpec, method, target.Path, key),
+			API:    key,
+			Method: method,
+			Path:   target.Path,
+		}
+	}
+	return nil
+}
+
+// clientIP returns the address of the client that sent r.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
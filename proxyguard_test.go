@@ -0,0 +1,93 @@
+package swagger
+
+import (
+	"net/netip"
+	"net/url"
+	"testing"
+)
+
+func TestAddressDenied(t *testing.T) {
+	guard := &proxyGuard{allowedCIDRs: []netip.Prefix{netip.MustParsePrefix("127.0.0.2/32")}}
+
+	tests := []struct {
+		name         string
+		addr         string
+		allowPrivate bool
+		wantDenied   bool
+	}{
+		{name: "public IPv4", addr: "93.184.216.34", wantDenied: false},
+		{name: "public IPv6", addr: "2606:2800:220:1::1", wantDenied: false},
+		{name: "loopback", addr: "127.0.0.1", allowPrivate: true, wantDenied: true},
+		{name: "IPv6 loopback", addr: "::1", allowPrivate: true, wantDenied: true},
+		{name: "IPv4-mapped loopback", addr: "::ffff:127.0.0.1", allowPrivate: true, wantDenied: true},
+		{name: "cloud metadata", addr: "169.254.169.254", allowPrivate: true, wantDenied: true},
+		{name: "unspecified", addr: "0.0.0.0", allowPrivate: true, wantDenied: true},
+		{name: "multicast", addr: "224.0.0.1", allowPrivate: true, wantDenied: true},
+		{name: "private for allowlisted target", addr: "10.0.0.1", wantDenied: true},
+		{name: "private for registered target", addr: "10.0.0.1", allowPrivate: true, wantDenied: false},
+		{name: "shared address space", addr: "100.64.0.1", wantDenied: true},
+		{name: "unique local IPv6", addr: "fd00::1", wantDenied: true},
+		{name: "allowed CIDR", addr: "127.0.0.2", wantDenied: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason := guard.addressDenied(netip.MustParseAddr(tt.addr), tt.allowPrivate)
+			if denied := reason != ""; denied != tt.wantDenied {
+				t.Errorf("addressDenied(%s, %v) = %q, want denied %v", tt.addr, tt.allowPrivate, reason, tt.wantDenied)
+			}
+		})
+	}
+}
+
+func TestMatchAPI(t *testing.T) {
+	guard := &proxyGuard{
+		apis: map[string]APIMetadata{
+			"pets":    {Name: "pets", URL: "http://pets.default.svc:8080/openapi.json"},
+			"orders":  {Name: "orders", URL: "https://shop.example.com/openapi.json"},
+			"billing": {Name: "billing", URL: "https://billing.example.com/spec.json", BaseURL: "https://shop.example.com/billing"},
+			"remote":  {Name: "remote", URL: "http://pets.default.svc:8080/openapi.json", Cluster: "east"},
+			"k8s":     {Name: "k8s", URL: "https://kubernetes.default.svc/openapi/v2", Fetch: func() (map[string]interface{}, error) { return nil, nil }},
+		},
+		servers: map[string][]*url.URL{
+			"orders": {mustParseURL(t, "https://shop.example.com/orders/v1")},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		cluster string
+		target  string
+		wantKey string
+		wantOK  bool
+	}{
+		{name: "spec URL origin", target: "http://pets.default.svc:8080/pets/1", wantKey: "pets", wantOK: true},
+		{name: "default port made explicit", target: "https://shop.example.com:443/cart", wantKey: "orders", wantOK: true},
+		{name: "learned server path", target: "https://shop.example.com/orders/v1/42", wantKey: "orders", wantOK: true},
+		{name: "longest base URL wins", target: "https://shop.example.com/billing/invoices", wantKey: "billing", wantOK: true},
+		{name: "base path is not a prefix match", target: "https://shop.example.com/billingx", wantKey: "orders", wantOK: true},
+		{name: "scheme must match", target: "http://shop.example.com/cart", wantOK: false},
+		{name: "port must match", target: "http://pets.default.svc:9090/pets", wantOK: false},
+		{name: "other cluster", cluster: "east", target: "http://pets.default.svc:8080/pets", wantKey: "remote", wantOK: true},
+		{name: "unknown cluster", cluster: "west", target: "http://pets.default.svc:8080/pets", wantOK: false},
+		{name: "fetched APIs never match", target: "https://kubernetes.default.svc/api/v1/pods", wantOK: false},
+		{name: "unknown host", target: "https://evil.example.com/", wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, ok := guard.matchAPI(tt.cluster, mustParseURL(t, tt.target))
+			if key != tt.wantKey || ok != tt.wantOK {
+				t.Errorf("matchAPI(%q, %s) = %q, %v, want %q, %v", tt.cluster, tt.target, key, ok, tt.wantKey, tt.wantOK)
+			}
+		})
+	}
+}
+
+// mustParseURL parses rawURL or fails the test.
+func mustParseURL(t *testing.T, rawURL string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("url.Parse(%q): %v", rawURL, err)
+	}
+	return u
+}
@@ -0,0 +1,148 @@
+package swagger
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Constants for path-based proxy routes
+const (
+	proxyRouteNoNamespace = "-" // Namespace segment of routes for APIs without a namespace
+	headerXForwardedProto = "X-Forwarded-Proto"
+
+	errMsgProxyRouteInvalid      = "Proxy route must be /proxy/{namespace}/{api}/{path}"
+	errMsgProxyRouteUnresolvable = "Failed to resolve upstream of API %s: %v"
+)
+
+// proxyTarget is the resolved destination of a proxied request.
+type proxyTarget struct {
+	cluster string           // Cluster whose connection the request is routed through
+	url     *url.URL         // Upstream URL
+	class   proxyTargetClass // How far the target is trusted
+	apiKey  string           // Key of the registered API the target belongs to, if any
+}
+
+// proxyRoutePath returns the path-based proxy route of an API, relative to the server's base path.
+func proxyRoutePath(key string, api APIMetadata) string {
+	namespace := api.Namespace
+	if namespace == "" {
+		namespace = proxyRouteNoNamespace
+	}
+	return httpPathProxy + url.PathEscape(namespace) + "/" + url.PathEscape(key)
+}
+
+// resolveRouteTarget resolves a /proxy/{namespace}/{api}/{path...} request. The upstream is the
+// API's first server as served to Swagger UI before rewriting (its spec servers resolved against
+// its spec URL), so internal hostnames never appear in the browser. On failure an error response
+// is sent and nil is returned.
+func (s *Server) resolveRouteTarget(w http.ResponseWriter, r *http.Request, route string) *proxyTarget {
+	// route is the escaped path after /proxy/; the remainder keeps its escaping for the upstream
+	parts := strings.SplitN(route, "/", 3)
+	if len(parts) < 2 {
+		s.logAndSendError(w, r, http.StatusBadRequest, errMsgProxyRouteInvalid, errMsgProxyRouteInvalid)
+		return nil
+	}
+	namespace, errNamespace := url.PathUnescape(parts[0])
+	key, errKey := url.PathUnescape(parts[1])
+	if errNamespace != nil || errKey != nil {
+		s.logAndSendError(w, r, http.StatusBadRequest, errMsgProxyRouteInvalid, errMsgProxyRouteInvalid)
+		return nil
+	}
+	rest := ""
+	if len(parts) == 3 {
+		rest = "/" + parts[2]
+	}
+
+	s.specsMux.RLock()
+	metadata, exists := s.specs[key]
+	s.specsMux.RUnlock()
+	if !exists || metadata.Fetch != nil || (metadata.Namespace != namespace && !(metadata.Namespace == "" && namespace == proxyRouteNoNamespace)) {
+		s.logAndSendError(w, r, http.StatusNotFound, fmt.Sprintf("%s: %s/%s", errMsgAPINotFound, namespace, key), errMsgAPINotFound)
+		return nil
+	}
+
+	base, err := s.upstreamBaseURL(key, metadata)
+	if err != nil {
+		s.logAndSendError(w, r, http.StatusBadGateway, fmt.Sprintf(errMsgProxyRouteUnresolvable, key, err), fmt.Sprintf(errMsgProxyRouteUnresolvable, key, "spec unavailable"))
+		return nil
+	}
+	target, err := url.Parse(strings.TrimSuffix(base.String(), "/") + rest)
+	if err != nil {
+		s.logAndSendError(w, r, http.StatusBadRequest, fmt.Sprintf(errMsgFailedToCreateProxyReq, err), fmt.Sprintf(errMsgFailedToCreateProxyReq, err))
+		return nil
+	}
+	target.RawQuery = r.URL.RawQuery
+
+	return &proxyTarget{cluster: metadata.Cluster, url: target, class: proxyTargetRegistered, apiKey: key}
+}
+
+// upstreamBaseURL returns the base URL requests to an API are forwarded to: the first server of
+// its spec on a host the API may reach (see serverHostAllowed), resolved against the spec URL
+// the same way updateSpecServerInfo does.
+func (s *Server) upstreamBaseURL(key string, metadata APIMetadata) (*url.URL, error) {
+	metadataURL, err := url.Parse(metadata.URL)
+	if err != nil {
+		return nil, err
+	}
+	cached, err := s.cachedSpecFor(key)
+	if err != nil {
+		return nil, err
+	}
+	spec := make(map[string]interface{}, len(cached))
+	for k, v := range cached {
+		spec[k] = v
+	}
+	s.updateSpecServerInfo(spec, metadataURL)
+	applyBaseURL(spec, metadata.BaseURL)
+
+	if swaggerVersion, _ := spec["swagger"].(string); swaggerVersion == "2.0" {
+		basePath, _ := spec["basePath"].(string)
+		return &url.URL{Scheme: metadataURL.Scheme, Host: metadataURL.Host, Path: basePath}, nil
+	}
+	for _, server := range specServerURLs(spec) {
+		if serverURL, err := url.Parse(server); err == nil && serverURL.IsAbs() && serverHostAllowed(metadata, serverURL) {
+			return serverURL, nil
+		}
+	}
+	return &url.URL{Scheme: metadataURL.Scheme, Host: metadataURL.Host}, nil
+}
+
+// rewriteSpecServersToProxy points the servers of a served spec at the API's path-based proxy
+// route on this server, so that Swagger UI sends "Try it out" requests through the proxy
+// without knowing the upstream host.
+func (s *Server) rewriteSpecServersToProxy(spec map[string]interface{}, r *http.Request, key string, metadata APIMetadata) {
+	rewriteSpecServers(spec, r, s.basePath+proxyRoutePath(key, metadata))
+}
+
+// rewriteSpecServers points the servers of a served spec at a route of this server, addressed
+// the way the client reached it.
+func rewriteSpecServers(spec map[string]interface{}, r *http.Request, routePath string) {
+	scheme, host := requestOrigin(r)
+	openAPIVersion, _ := spec["openapi"].(string)
+	swaggerVersion, _ := spec["swagger"].(string)
+	switch {
+	case strings.HasPrefix(openAPIVersion, "3."):
+		spec["servers"] = []interface{}{
+			map[string]interface{}{"url": fmt.Sprintf("%s://%s%s", scheme, host, routePath)},
+		}
+	case swaggerVersion == "2.0":
+		spec["host"] = host
+		spec["basePath"] = routePath
+		spec["schemes"] = []interface{}{scheme}
+	}
+}
+
+// requestOrigin returns the scheme and host the client used to reach this server,
+// honouring X-Forwarded-Proto set by an Ingress or load balancer.
+func requestOrigin(r *http.Request) (string, string) {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if forwarded := r.Header.Get(headerXForwardedProto); forwarded != "" {
+		scheme = strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+	return scheme, r.Host
+}
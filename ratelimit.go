@@ -0,0 +1,198 @@
+package swagger
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Constants for proxy rate limiting
+const (
+	envVarProxyClientIdentityHeader = "PROXY_CLIENT_IDENTITY_HEADER" // Header identifying the user, set by an authenticating proxy in front
+	envVarProxyRateLimitClientRPS   = "PROXY_RATE_LIMIT_CLIENT_RPS"  // Requests per second per client, across all APIs
+	envVarProxyRateLimitClientBurst = "PROXY_RATE_LIMIT_CLIENT_BURST"
+	envVarProxyRateLimitAPIRPS      = "PROXY_RATE_LIMIT_API_RPS" // Requests per second per target API, across all clients
+	envVarProxyRateLimitAPIBurst    = "PROXY_RATE_LIMIT_API_BURST"
+
+	rateLimiterIdleTTL   = 10 * time.Minute // Buckets unused for this long are dropped
+	rateLimiterSweepTick = time.Minute      // How often idle buckets are looked for
+
+	rateLimitScopeClient    = "client"     // Bucket per client across all APIs
+	rateLimitScopeAPI       = "api"        // Bucket per API across all clients
+	rateLimitScopeAPIClient = "api_client" // Bucket per client and API
+
+	headerRetryAfter = "Retry-After"
+
+	logMsgProxyThrottled   = "Proxy request from %s to %s throttled by the %s rate limit; retry in %s"
+	errMsgProxyRateLimited = "Too many requests to API %s; retry after %d second(s)"
+)
+
+// RateLimit is a token bucket: RequestsPerSecond is the refill rate and Burst the bucket size.
+// A zero RequestsPerSecond means unlimited.
+type RateLimit struct {
+	RequestsPerSecond float64 `json:"requestsPerSecond"`
+	Burst             int     `json:"burst,omitempty"` // Defaults to RequestsPerSecond rounded up
+}
+
+// APIRateLimit configures the rate limits of one API.
+type APIRateLimit struct {
+	RateLimit            // Shared by all clients of the API; overrides PROXY_RATE_LIMIT_API_*
+	PerClient *RateLimit `json:"perClient,omitempty"` // Applied to each client of the API separately
+}
+
+// limiter returns a token bucket for the limit, or nil if it is unlimited.
+func (l RateLimit) limiter() *rate.Limiter {
+	if l.RequestsPerSecond <= 0 {
+		return nil
+	}
+	burst := l.Burst
+	if burst <= 0 {
+		burst = int(math.Ceil(l.RequestsPerSecond))
+	}
+	return rate.NewLimiter(rate.Limit(l.RequestsPerSecond), burst)
+}
+
+// rateLimiterEntry is a token bucket and the limit it was created for.
+type rateLimiterEntry struct {
+	limit    RateLimit
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// rateLimiters holds the token buckets of the proxy, created on first use.
+type rateLimiters struct {
+	client RateLimit // Default per-client limit (PROXY_RATE_LIMIT_CLIENT_*)
+	api    RateLimit // Default per-API limit (PROXY_RATE_LIMIT_API_*)
+
+	mux       sync.Mutex
+	buckets   map[string]*rateLimiterEntry // By scope and key
+	lastSweep time.Time
+}
+
+// loadRateLimiters reads the default rate limits from the environment.
+func (s *Server) loadRateLimiters() *rateLimiters {
+	return &rateLimiters{
+		client: RateLimit{
+			RequestsPerSecond: s.getEnvFloat(envVarProxyRateLimitClientRPS, 0),
+			Burst:             s.getEnvInt(envVarProxyRateLimitClientBurst, 0),
+		},
+		api: RateLimit{
+			RequestsPerSecond: s.getEnvFloat(envVarProxyRateLimitAPIRPS, 0),
+			Burst:             s.getEnvInt(envVarProxyRateLimitAPIBurst, 0),
+		},
+		buckets: make(map[string]*rateLimiterEntry),
+	}
+}
+
+// reserve takes a token from the bucket of key, creating it for limit if needed. It returns nil
+// if the limit is unlimited.
+func (l *rateLimiters) reserve(key string, limit RateLimit, now time.Time) *rate.Reservation {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	if now.Sub(l.lastSweep) > rateLimiterSweepTick {
+		for bucketKey, entry := range l.buckets {
+			if now.Sub(entry.lastSeen) > rateLimiterIdleTTL {
+				delete(l.buckets, bucketKey)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	entry, ok := l.buckets[key]
+	if !ok || entry.limit != limit {
+		limiter := limit.limiter()
+		if limiter == nil {
+			delete(l.buckets, key)
+			return nil
+		}
+		entry = &rateLimiterEntry{limit: limit, limiter: limiter}
+		l.buckets[key] = entry
+	}
+	entry.lastSeen = now
+	return entry.limiter.ReserveN(now, 1)
+}
+
+// clientIdentity returns who is making a request: the value of PROXY_CLIENT_IDENTITY_HEADER if
+// configured and present, otherwise the client IP.
+func (s *Server) clientIdentity(r *http.Request) string {
+	if s.identityHeader != "" {
+		if identity := r.Header.Get(s.identityHeader); identity != "" {
+			return identity
+		}
+	}
+	return clientIP(r)
+}
+
+// allowRate takes a token from every bucket that applies to a proxied request: the client's,
+// the target API's, and the client's bucket for that API. If any of them is empty, no token is
+// taken, a 429 response with Retry-After is sent and false is returned.
+func (s *Server) allowRate(w http.ResponseWriter, r *http.Request, target *proxyTarget) bool {
+	client := s.clientIdentity(r)
+	api := target.apiKey
+	if api == "" {
+		api = target.url.Host // Allowlisted targets are limited per host
+	}
+
+	apiLimit := s.rateLimits.api
+	var perClient *RateLimit
+	if target.apiKey != "" {
+		s.specsMux.RLock()
+		if configured := s.specs[target.apiKey].RateLimit; configured != nil {
+			apiLimit, perClient = configured.RateLimit, configured.PerClient
+		}
+		s.specsMux.RUnlock()
+	}
+
+	type bucket struct {
+		scope string
+		key   string
+		limit RateLimit
+	}
+	buckets := []bucket{
+		{rateLimitScopeClient, client, s.rateLimits.client},
+		{rateLimitScopeAPI, api, apiLimit},
+	}
+	if perClient != nil {
+		buckets = append(buckets, bucket{rateLimitScopeAPIClient, api + "\x00" + client, *perClient})
+	}
+
+	now := time.Now()
+	var reservations []*rate.Reservation
+	for _, b := range buckets {
+		reservation := s.rateLimits.reserve(b.scope+"\x00"+b.key, b.limit, now)
+		if reservation == nil {
+			continue
+		}
+		reservations = append(reservations, reservation)
+		if delay := reservation.DelayFrom(now); !reservation.OK() || delay > 0 {
+			for _, taken := range reservations {
+				taken.CancelAt(now)
+			}
+			if !reservation.OK() {
+				delay = time.Second // Burst of zero: the request can never pass, but tell the client to back off
+			}
+			s.rejectRate(w, r, api, b.scope, delay)
+			return false
+		}
+	}
+	return true
+}
+
+// rejectRate sends the 429 response of a throttled request and counts it.
+func (s *Server) rejectRate(w http.ResponseWriter, r *http.Request, api, scope string, delay time.Duration) {
+	retryAfter := int(math.Ceil(delay.Seconds()))
+	s.metrics.proxyThrottled.inc(api, scope)
+	s.logger.Warnf(logMsgProxyThrottled, s.clientIdentity(r), api, scope, delay.Round(time.Millisecond))
+
+	w.Header().Set(headerRetryAfter, strconv.Itoa(retryAfter))
+	s.writeJSON(w, r, http.StatusTooManyRequests, &proxyError{
+		Error: fmt.Sprintf(errMsgProxyRateLimited, api, retryAfter),
+		API:   api,
+	})
+}
@@ -0,0 +1,118 @@
+package swagger
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRateLimitersReserve(t *testing.T) {
+	// request is one reservation attempt, at an offset from the start of the test.
+	type request struct {
+		at    time.Duration
+		limit RateLimit
+	}
+	perSecond := RateLimit{RequestsPerSecond: 1, Burst: 2}
+
+	tests := []struct {
+		name     string
+		requests []request
+		want     []bool // Whether each request passes without waiting
+	}{
+		{
+			name:     "unlimited",
+			requests: []request{{0, RateLimit{}}, {0, RateLimit{}}, {0, RateLimit{}}},
+			want:     []bool{true, true, true},
+		},
+		{
+			name:     "burst then refill",
+			requests: []request{{0, perSecond}, {0, perSecond}, {0, perSecond}, {time.Second, perSecond}, {time.Second, perSecond}},
+			want:     []bool{true, true, false, true, false},
+		},
+		{
+			name:     "burst defaults to the rate rounded up",
+			requests: []request{{0, RateLimit{RequestsPerSecond: 1.5}}, {0, RateLimit{RequestsPerSecond: 1.5}}, {0, RateLimit{RequestsPerSecond: 1.5}}},
+			want:     []bool{true, true, false},
+		},
+		{
+			name:     "changed limit starts a new bucket",
+			requests: []request{{0, perSecond}, {0, perSecond}, {0, RateLimit{RequestsPerSecond: 1, Burst: 3}}},
+			want:     []bool{true, true, true},
+		},
+		{
+			name:     "removed limit drops the bucket",
+			requests: []request{{0, perSecond}, {0, perSecond}, {0, RateLimit{}}, {0, perSecond}},
+			want:     []bool{true, true, true, true},
+		},
+		{
+			name:     "negative burst falls back to the default",
+			requests: []request{{0, RateLimit{RequestsPerSecond: 1, Burst: -1}}, {0, RateLimit{RequestsPerSecond: 1, Burst: -1}}},
+			want:     []bool{true, false},
+		},
+	}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiters := &rateLimiters{buckets: make(map[string]*rateLimiterEntry), lastSweep: start}
+			var got []bool
+			for _, req := range tt.requests {
+				now := start.Add(req.at)
+				reservation := limiters.reserve("client\x00alice", req.limit, now)
+				passed := reservation == nil || (reservation.OK() && reservation.DelayFrom(now) == 0)
+				if reservation != nil && !passed {
+					reservation.CancelAt(now) // As allowRate does, so rejected requests take no token
+				}
+				got = append(got, passed)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("reservations passed = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowRate(t *testing.T) {
+	s := NewServer()
+	s.rateLimits = &rateLimiters{
+		client:  RateLimit{RequestsPerSecond: 10, Burst: 5},
+		api:     RateLimit{RequestsPerSecond: 0.5, Burst: 1},
+		buckets: make(map[string]*rateLimiterEntry),
+	}
+	target := &proxyTarget{url: mustParseURL(t, "https://api.example.com/pets")}
+
+	tests := []struct {
+		name           string
+		wantAllowed    bool
+		wantRetryAfter string
+	}{
+		{name: "first request", wantAllowed: true},
+		{name: "API bucket empty", wantAllowed: false, wantRetryAfter: "2"},
+		{name: "still empty", wantAllowed: false, wantRetryAfter: "2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/proxy/?proxyUrl=https://api.example.com/pets", nil)
+			if allowed := s.allowRate(w, r, target); allowed != tt.wantAllowed {
+				t.Fatalf("allowRate() = %v, want %v", allowed, tt.wantAllowed)
+			}
+			if tt.wantAllowed {
+				return
+			}
+			if w.Code != http.StatusTooManyRequests || w.Header().Get(headerRetryAfter) != tt.wantRetryAfter {
+				t.Errorf("allowRate() responded %d with Retry-After %q, want %d with %q", w.Code, w.Header().Get(headerRetryAfter), http.StatusTooManyRequests, tt.wantRetryAfter)
+			}
+		})
+	}
+
+	// Rejected requests return the tokens they took from buckets checked earlier
+	entry := s.rateLimits.buckets[rateLimitScopeClient+"\x00"+clientIP(httptest.NewRequest(http.MethodGet, "/", nil))]
+	if entry == nil {
+		t.Fatal("client bucket was not created")
+	}
+	if tokens := entry.limiter.TokensAt(entry.lastSeen); tokens < 3.99 {
+		t.Errorf("client bucket has %.2f tokens left, want 4", tokens)
+	}
+}
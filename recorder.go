@@ -0,0 +1,458 @@
+package swagger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Constants for recording proxied traffic as spec examples
+const (
+	envVarProxyRecordExamples        = "PROXY_RECORD_EXAMPLES"                 // Record request/response pairs of proxied calls
+	envVarProxyRecordedExamplesPerOp = "PROXY_RECORDED_EXAMPLES_PER_OPERATION" // Recordings kept per operation
+	envVarProxyRecordMaxBodyBytes    = "PROXY_RECORD_MAX_BODY_BYTES"           // Calls with larger bodies are not recorded
+	envVarMergeRecordedExamples      = "MERGE_RECORDED_EXAMPLES"               // Add recordings to the examples of served specs
+
+	defaultRecordedExamplesPerOp = 5
+	defaultRecordMaxBodyBytes    = 64 << 10 // 64 KiB
+	pathSuffixRecordedExamples   = "/recorded-examples"
+	recordedExampleNamePrefix    = "recorded-"
+
+	logMsgExampleRecorded       = "Recorded example of %s %s of %s with status %d"
+	logMsgExampleEncodedSkipped = "Not recording %s %s of %s: unsupported Content-Encoding %q"
+)
+
+// recordedExample is a sanitized request/response pair of one proxied call. Only JSON bodies are
+// recorded, with sensitive fields redacted like in the audit log.
+type recordedExample struct {
+	Recorded            time.Time   `json:"recorded"`
+	URL                 string      `json:"url"` // Upstream path and query, sensitive query values redacted
+	Status              int         `json:"status"`
+	RequestContentType  string      `json:"requestContentType,omitempty"`
+	RequestBody         interface{} `json:"requestBody,omitempty"`
+	ResponseContentType string      `json:"responseContentType,omitempty"`
+	ResponseBody        interface{} `json:"responseBody,omitempty"`
+}
+
+// recordedOperation holds the most recent recordings of one operation, newest first.
+type recordedOperation struct {
+	method      string
+	path        string
+	operationID string
+	examples    []recordedExample
+}
+
+// exampleRecorder keeps recent recordings by API key and operation ("METHOD /path/{template}").
+type exampleRecorder struct {
+	redactor
+	maxBodyBytes int
+	perOperation int
+
+	mux  sync.Mutex
+	apis map[string]map[string]*recordedOperation
+}
+
+// exampleRecording is a proxied call being recorded.
+type exampleRecording struct {
+	api         string
+	match       *operationMatch
+	url         string
+	contentType string
+	requestBody *captureReader // nil if the request has no body
+	started     time.Time
+}
+
+// recordingBody captures a response body as it is streamed to the client and records the call
+// once the body has been read completely.
+type recordingBody struct {
+	io.ReadCloser
+	limit    int
+	buf      bytes.Buffer
+	complete bool
+	onClose  sync.Once
+	done     func(body []byte)
+}
+
+// newExampleRecorder creates the recorder configured by the PROXY_RECORD* variables.
+func (s *Server) newExampleRecorder() *exampleRecorder {
+	return &exampleRecorder{
+		redactor:     newRedactor(),
+		maxBodyBytes: s.getEnvInt(envVarProxyRecordMaxBodyBytes, defaultRecordMaxBodyBytes),
+		perOperation: s.getEnvInt(envVarProxyRecordedExamplesPerOp, defaultRecordedExamplesPerOp),
+		apis:         make(map[string]map[string]*recordedOperation),
+	}
+}
+
+// shouldRecordExamples reports whether calls to an API are recorded: its recordExamples setting
+// if present, otherwise PROXY_RECORD_EXAMPLES.
+func (s *Server) shouldRecordExamples(key string) bool {
+	s.specsMux.RLock()
+	defer s.specsMux.RUnlock()
+	if override := s.specs[key].RecordExamples; override != nil {
+		return *override
+	}
+	return s.recordExamples
+}
+
+// shouldMergeRecordedExamples reports whether recordings are added to the served spec of an API:
+// its mergeRecordedExamples setting if present, otherwise MERGE_RECORDED_EXAMPLES.
+func (s *Server) shouldMergeRecordedExamples(metadata APIMetadata) bool {
+	if metadata.MergeRecordedExamples != nil {
+		return *metadata.MergeRecordedExamples
+	}
+	return s.mergeRecorded
+}
+
+// prepareRecording starts recording a proxied call to a registered API, capturing its request
+// body as it is forwarded. It returns nil if recording is disabled for the API or the call
+// matches no operation of its spec.
+func (s *Server) prepareRecording(r *http.Request, target *proxyTarget) *exampleRecording {
+	if !s.shouldRecordExamples(target.apiKey) {
+		return nil
+	}
+	spec, err := s.cachedSpecFor(target.apiKey)
+	if err != nil {
+		return nil
+	}
+	match, _ := findOperation(spec, r.Method, target.url)
+	if match == nil {
+		return nil
+	}
+
+	relative := *target.url
+	relative.Scheme, relative.Host = "", ""
+	recording := &exampleRecording{
+		api:         target.apiKey,
+		match:       match,
+		url:         s.recorder.redactURL(&relative),
+		contentType: r.Header.Get(headerContentType),
+		started:     time.Now(),
+	}
+	if r.Body != nil && r.Body != http.NoBody {
+		recording.requestBody = &captureReader{ReadCloser: r.Body, limit: s.recorder.maxBodyBytes + 1}
+		r.Body = recording.requestBody
+	}
+	return recording
+}
+
+// recordResponse arranges for a proxied response to be recorded with its request once its body
+// has been passed to the client.
+func (s *Server) recordResponse(resp *http.Response, recording *exampleRecording) {
+	status, contentType := resp.StatusCode, resp.Header.Get(headerContentType)
+	encoding := resp.Header.Get(headerContentEncoding)
+	resp.Body = &recordingBody{
+		ReadCloser: resp.Body,
+		limit:      s.recorder.maxBodyBytes,
+		done: func(body []byte) {
+			if len(body) > 0 {
+				decoded, ok := decodeBody(encoding, body, int64(s.recorder.maxBodyBytes))
+				if !ok {
+					s.logger.Debugf(logMsgExampleEncodedSkipped, recording.match.Method, recording.match.Path, recording.api, encoding)
+					return
+				}
+				body = decoded
+			}
+			s.recordExample(recording, status, contentType, body)
+		},
+	}
+}
+
+// recordExample stores a completed call if both of its bodies are empty or complete JSON.
+func (s *Server) recordExample(recording *exampleRecording, status int, contentType string, responseBody []byte) {
+	example := recordedExample{
+		Recorded:            recording.started.UTC(),
+		URL:                 recording.url,
+		Status:              status,
+		ResponseContentType: contentType,
+	}
+	var ok bool
+	if recording.requestBody != nil && recording.requestBody.n > 0 {
+		if int64(recording.requestBody.buf.Len()) < recording.requestBody.n || recording.requestBody.buf.Len() > s.recorder.maxBodyBytes {
+			return // Not captured completely
+		}
+		example.RequestContentType = recording.contentType
+		if example.RequestBody, ok = s.recorder.sanitizeBody(recording.contentType, recording.requestBody.buf.Bytes()); !ok {
+			return
+		}
+	}
+	if len(responseBody) > 0 {
+		if example.ResponseBody, ok = s.recorder.sanitizeBody(contentType, responseBody); !ok {
+			return
+		}
+	}
+
+	operationKey := recording.match.Method + " " + recording.match.Path
+	r := s.recorder
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	operations, exists := r.apis[recording.api]
+	if !exists {
+		operations = make(map[string]*recordedOperation)
+		r.apis[recording.api] = operations
+	}
+	operation, exists := operations[operationKey]
+	if !exists {
+		operationID, _ := recording.match.Operation["operationId"].(string)
+		operation = &recordedOperation{method: recording.match.Method, path: recording.match.Path, operationID: operationID}
+		operations[operationKey] = operation
+	}
+	if len(operation.examples) > 0 && sameExample(operation.examples[0], example) {
+		operation.examples[0].Recorded = example.Recorded // Repeated calls only refresh the newest recording
+		return
+	}
+	operation.examples = append([]recordedExample{example}, operation.examples...)
+	if len(operation.examples) > r.perOperation {
+		operation.examples = operation.examples[:r.perOperation]
+	}
+	s.logger.Debugf(logMsgExampleRecorded, recording.match.Method, recording.match.Path, recording.api, status)
+}
+
+// sameExample reports whether two recordings differ only in when they were made.
+func sameExample(a, b recordedExample) bool {
+	a.Recorded = b.Recorded
+	return reflect.DeepEqual(a, b)
+}
+
+// sanitizeBody decodes a JSON body and redacts its sensitive fields. Other bodies can't be
+// sanitized reliably and are refused.
+func (r *exampleRecorder) sanitizeBody(contentType string, body []byte) (interface{}, bool) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if !isJSONMediaType(mediaType) {
+		return nil, false
+	}
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return nil, false
+	}
+	return r.redactValue(value), true
+}
+
+// forgetExamples drops the recordings of APIs that are no longer registered.
+func (r *exampleRecorder) forgetExamples(specs map[string]APIMetadata) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	for key := range r.apis {
+		if _, ok := specs[key]; !ok {
+			delete(r.apis, key)
+		}
+	}
+}
+
+// snapshot returns copies of the recorded operations of an API, by operation key.
+func (r *exampleRecorder) snapshot(key string) map[string]recordedOperation {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	operations := make(map[string]recordedOperation, len(r.apis[key]))
+	for operationKey, operation := range r.apis[key] {
+		copied := *operation
+		copied.examples = append([]recordedExample(nil), operation.examples...)
+		operations[operationKey] = copied
+	}
+	return operations
+}
+
+// Read implements io.Reader.
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if remaining := b.limit + 1 - b.buf.Len(); remaining > 0 {
+		b.buf.Write(p[:min(n, remaining)])
+	}
+	if err == io.EOF {
+		b.complete = true
+	}
+	return n, err
+}
+
+// Close implements io.Closer. A body that was read to the end within the size limit is recorded.
+func (b *recordingBody) Close() error {
+	b.onClose.Do(func() {
+		if b.complete && b.buf.Len() <= b.limit {
+			b.done(b.buf.Bytes())
+		}
+	})
+	return b.ReadCloser.Close()
+}
+
+// serveRecordedExamples serves the recordings of an API at /api/{name}/recorded-examples. Each
+// operation lists its raw recordings and the same data as OpenAPI 3 requestBody and responses
+// "examples" objects, ready to be pasted into the spec.
+func (s *Server) serveRecordedExamples(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimSuffix(strings.TrimPrefix(s.stripBasePath(r.URL.Path), httpPathAPI), pathSuffixRecordedExamples)
+	s.specsMux.RLock()
+	_, exists := s.specs[key]
+	s.specsMux.RUnlock()
+	if !exists {
+		s.logAndSendError(w, r, http.StatusNotFound, fmt.Sprintf("%s: %s", errMsgAPINotFound, key), errMsgAPINotFound)
+		return
+	}
+
+	operations := make(map[string]interface{})
+	for operationKey, operation := range s.recorder.snapshot(key) {
+		requestContent, responses := exampleObjects(operation.examples)
+		entry := map[string]interface{}{
+			"recordings": operation.examples,
+			"responses":  responses,
+		}
+		if operation.operationID != "" {
+			entry["operationId"] = operation.operationID
+		}
+		if len(requestContent) > 0 {
+			entry["requestBody"] = map[string]interface{}{"content": requestContent}
+		}
+		operations[operationKey] = entry
+	}
+	s.writeJSON(w, r, http.StatusOK, map[string]interface{}{"api": key, "operations": operations})
+}
+
+// exampleObjects converts recordings to OpenAPI 3 examples: request body content by media type,
+// and response content by status code and media type. Examples are named recorded-1 (newest),
+// recorded-2, ... per media type.
+func exampleObjects(examples []recordedExample) (map[string]interface{}, map[string]interface{}) {
+	requestContent := make(map[string]interface{})
+	responses := make(map[string]interface{})
+	add := func(content map[string]interface{}, contentType string, value interface{}, recorded time.Time) {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return
+		}
+		media, ok := content[mediaType].(map[string]interface{})
+		if !ok {
+			media = map[string]interface{}{"examples": make(map[string]interface{})}
+			content[mediaType] = media
+		}
+		named := media["examples"].(map[string]interface{})
+		named[recordedExampleNamePrefix+strconv.Itoa(len(named)+1)] = map[string]interface{}{
+			"summary": "Recorded " + recorded.Format(time.RFC3339),
+			"value":   value,
+		}
+	}
+
+	for _, example := range examples {
+		if example.RequestBody != nil {
+			add(requestContent, example.RequestContentType, example.RequestBody, example.Recorded)
+		}
+		if example.ResponseBody != nil {
+			status := strconv.Itoa(example.Status)
+			response, ok := responses[status].(map[string]interface{})
+			if !ok {
+				response = map[string]interface{}{"content": make(map[string]interface{})}
+				responses[status] = response
+			}
+			add(response["content"].(map[string]interface{}), example.ResponseContentType, example.ResponseBody, example.Recorded)
+		}
+	}
+	return requestContent, responses
+}
+
+// mergeRecordedExamples adds the recordings of an API to the examples of its served spec. Only
+// media types the spec documents for an operation get examples, and existing examples are kept;
+// shared ($ref) request bodies and responses are left alone. The paths of spec are copied before
+// they are modified, since the fetched document may be shared with the spec cache.
+func (s *Server) mergeRecordedExamples(spec map[string]interface{}, key string) {
+	operations := s.recorder.snapshot(key)
+	if len(operations) == 0 {
+		return
+	}
+	paths, ok := deepCopyJSON(spec["paths"]).(map[string]interface{})
+	if !ok {
+		return
+	}
+	spec["paths"] = paths
+	_, isSpec3 := spec["openapi"]
+
+	keys := make([]string, 0, len(operations))
+	for operationKey := range operations {
+		keys = append(keys, operationKey)
+	}
+	sort.Strings(keys)
+	for _, operationKey := range keys {
+		recorded := operations[operationKey]
+		operation := asObject(asObject(paths[recorded.path])[strings.ToLower(recorded.method)])
+		if operation == nil {
+			continue
+		}
+		requestContent, responses := exampleObjects(recorded.examples)
+		if isSpec3 {
+			if requestBody := asObject(operation["requestBody"]); requestBody["$ref"] == nil {
+				mergeContentExamples(asObject(requestBody["content"]), requestContent)
+			}
+		}
+		documented := asObject(operation["responses"])
+		for status, response := range responses {
+			target := asObject(documented[status])
+			if target == nil || target["$ref"] != nil {
+				continue
+			}
+			recordedContent := asObject(asObject(response)["content"])
+			if isSpec3 {
+				mergeContentExamples(asObject(target["content"]), recordedContent)
+				continue
+			}
+			// Swagger 2.0 has a single example per media type
+			if target["schema"] == nil {
+				continue
+			}
+			examples := asObject(target["examples"])
+			if examples == nil {
+				examples = make(map[string]interface{})
+				target["examples"] = examples
+			}
+			for mediaType, media := range recordedContent {
+				if _, exists := examples[mediaType]; !exists {
+					newest := asObject(asObject(media)["examples"])[recordedExampleNamePrefix+"1"]
+					examples[mediaType] = asObject(newest)["value"]
+				}
+			}
+		}
+	}
+}
+
+// mergeContentExamples adds recorded examples to the documented media types of an OpenAPI 3
+// content object. Media types with a single "example" are left alone, since a media type may
+// not have both.
+func mergeContentExamples(content, recorded map[string]interface{}) {
+	for mediaType, recordedMedia := range recorded {
+		media := asObject(content[mediaType])
+		if media == nil || media["example"] != nil {
+			continue
+		}
+		examples := asObject(media["examples"])
+		if examples == nil {
+			examples = make(map[string]interface{})
+			media["examples"] = examples
+		}
+		for name, example := range asObject(asObject(recordedMedia)["examples"]) {
+			if _, exists := examples[name]; !exists {
+				examples[name] = example
+			}
+		}
+	}
+}
+
+// deepCopyJSON copies a decoded JSON value.
+func deepCopyJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			copied[key] = deepCopyJSON(item)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, item := range v {
+			copied[i] = deepCopyJSON(item)
+		}
+		return copied
+	}
+	return value
+}
@@ -0,0 +1,255 @@
+package swagger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Constants for the reverse proxy
+const (
+	envVarProxyMaxRequestBytes  = "PROXY_MAX_REQUEST_BYTES"  // Largest request body forwarded upstream
+	envVarProxyMaxResponseBytes = "PROXY_MAX_RESPONSE_BYTES" // Largest response body returned to the client
+	envVarProxyTimeoutSeconds   = "PROXY_TIMEOUT_SECONDS"    // How long to wait for upstream response headers
+
+	defaultProxyMaxRequestBytes  = 10 << 20  // 10 MiB
+	defaultProxyMaxResponseBytes = 100 << 20 // 100 MiB
+	defaultProxyTimeout          = 30 * time.Second
+	proxyFlushInterval           = 100 * time.Millisecond // Streamed responses are flushed at least this often
+
+	headerForwarded     = "Forwarded"
+	headerXForwardedFor = "X-Forwarded-For"
+
+	logMsgInvalidEnvInt        = "Invalid integer value for %s: '%s'. Using default: %d"
+	logMsgInvalidEnvFloat      = "Invalid number value for %s: '%s'. Using default: %g"
+	logMsgProxyUpstreamError   = "Proxy request to %s failed: %v"
+	errMsgProxyRequestTooLarge = "Request body exceeds the proxy limit of %d bytes"
+	errMsgProxyUpstreamTimeout = "Upstream did not respond in time"
+	errMsgProxyUpstreamFailed  = "Upstream request failed"
+)
+
+// errProxyResponseTooLarge is returned while streaming a response body that exceeds the limit.
+var errProxyResponseTooLarge = errors.New("proxy response exceeds limit")
+
+// proxyLimits bounds the resources a single proxied request may use.
+type proxyLimits struct {
+	maxRequestBytes  int64         // Largest request body; 0 means unlimited
+	maxResponseBytes int64         // Largest response body; 0 means unlimited
+	timeout          time.Duration // Upstream response header timeout; 0 means none
+	streamIdle       time.Duration // Idle timeout of streamed responses; 0 means none
+}
+
+// proxyUpstreamKey is the request context key carrying the resolved upstream of a proxied request.
+type proxyUpstreamKey struct{}
+
+// proxyUpstream is where a proxied request is sent and which client sends it.
+type proxyUpstream struct {
+	url          *url.URL
+	client       *http.Client
+	credentials  http.Header       // Injected credential headers, if any
+	headerPolicy HeaderPolicy      // Header rules of the target API
+	portalHost   string            // Host the browser addressed this server by
+	contract     *contractCheck    // Operation the response is checked against, if contract checking is enabled
+	recording    *exampleRecording // Call recorded as a spec example, if recording is enabled
+}
+
+// loadProxyLimits reads the proxy limits from PROXY_MAX_REQUEST_BYTES, PROXY_MAX_RESPONSE_BYTES,
+// PROXY_TIMEOUT_SECONDS and PROXY_STREAM_IDLE_TIMEOUT_SECONDS.
+func (s *Server) loadProxyLimits() proxyLimits {
+	return proxyLimits{
+		maxRequestBytes:  int64(s.getEnvInt(envVarProxyMaxRequestBytes, defaultProxyMaxRequestBytes)),
+		maxResponseBytes: int64(s.getEnvInt(envVarProxyMaxResponseBytes, defaultProxyMaxResponseBytes)),
+		timeout:          time.Duration(s.getEnvInt(envVarProxyTimeoutSeconds, int(defaultProxyTimeout/time.Second))) * time.Second,
+		streamIdle:       time.Duration(s.getEnvInt(envVarProxyStreamIdleTimeout, int(defaultProxyStreamIdleTimeout/time.Second))) * time.Second,
+	}
+}
+
+// newProxyTransport returns the transport shared by all proxied requests of one target class.
+// Connections are pooled across requests; dial is used for every new connection.
+func newProxyTransport(dial func(ctx context.Context, network, address string) (net.Conn, error), timeout time.Duration) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil // Addresses are checked at dial time, which an HTTP proxy would bypass
+	transport.DialContext = dial
+	transport.MaxIdleConns = 100
+	transport.MaxIdleConnsPerHost = 20
+	transport.IdleConnTimeout = 90 * time.Second
+	transport.TLSHandshakeTimeout = 10 * time.Second
+	transport.ResponseHeaderTimeout = timeout
+	return transport
+}
+
+// newReverseProxy creates the reverse proxy that forwards requests to the upstream stored in
+// their context. Hop-by-hop headers are dropped in both directions, request and response
+// bodies are streamed, and upstream failures are mapped to 502 or 504.
+func (s *Server) newReverseProxy() *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Rewrite:        s.rewriteProxyRequest,
+		Transport:      upstreamTransport{},
+		FlushInterval:  proxyFlushInterval,
+		ModifyResponse: s.modifyProxyResponse,
+		ErrorHandler:   s.handleProxyError,
+		ErrorLog:       log.New(s.logger.WriterLevel(logrus.WarnLevel), "", 0), // E.g. aborted response copies
+	}
+}
+
+// rewriteProxyRequest points the outbound request at its upstream and adds the
+// X-Forwarded-* and Forwarded headers describing the original request.
+func (s *Server) rewriteProxyRequest(pr *httputil.ProxyRequest) {
+	upstream := pr.In.Context().Value(proxyUpstreamKey{}).(proxyUpstream)
+	pr.Out.URL = upstream.url
+	pr.Out.Host = ""       // Send the upstream's own host
+	pr.Out.RequestURI = "" // The upstream client rejects server-side request URIs
+	upgrade := saveUpgradeHeaders(pr.Out.Header)
+	filterRequestHeaders(pr.Out.Header, upstream.headerPolicy, s.headerPolicy.request)
+	restoreHeaders(pr.Out.Header, upgrade)
+	for name, values := range upstream.credentials {
+		pr.Out.Header[name] = values
+	}
+
+	// Extend the forwarding chain of any proxy in front of this server
+	if prior, ok := pr.In.Header[headerXForwardedFor]; ok {
+		pr.Out.Header[headerXForwardedFor] = prior
+	}
+	pr.SetXForwarded()
+	scheme, host := requestOrigin(pr.In)
+	pr.Out.Header.Set(headerXForwardedProto, scheme)
+
+	forwarded := fmt.Sprintf("for=%s;host=%s;proto=%s", forwardedNode(clientIP(pr.In)), strconv.Quote(host), scheme)
+	if prior := pr.In.Header.Get(headerForwarded); prior != "" {
+		forwarded = prior + ", " + forwarded
+	}
+	pr.Out.Header.Set(headerForwarded, forwarded)
+}
+
+// forwardedNode formats a client address as a Forwarded "for" node (RFC 7239), quoting IPv6 addresses.
+func forwardedNode(ip string) string {
+	if strings.Contains(ip, ":") {
+		return strconv.Quote("[" + ip + "]")
+	}
+	return ip
+}
+
+// modifyProxyResponse removes echoed credential headers and headers denied by the header
+// policy from upstream responses. Streams (Server-Sent Events and upgraded connections) are
+// closed when idle; other responses whose declared length exceeds the response limit are
+// rejected, and bodies that grow past it are cut off.
+func (s *Server) modifyProxyResponse(resp *http.Response) error {
+	upstream, ok := resp.Request.Context().Value(proxyUpstreamKey{}).(proxyUpstream)
+	if ok {
+		for name := range upstream.credentials {
+			resp.Header.Del(name)
+		}
+		upgrade := saveUpgradeHeaders(resp.Header)
+		filterHeaders(resp.Header, upstream.headerPolicy.Response, s.headerPolicy.response)
+		restoreHeaders(resp.Header, upgrade)
+		filterSetCookies(resp.Header, upstream.portalHost)
+	}
+
+	streaming := isStreamingResponse(resp)
+	if upstream.contract != nil {
+		if err := s.checkResponseContract(resp, upstream.contract, !streaming); err != nil {
+			return err
+		}
+	}
+	truncate, _ := resp.Request.Context().Value(faultTruncateKey{}).(bool)
+	if truncate && !streaming {
+		resp.Body = newTruncatedBody(resp)
+	} else if upstream.recording != nil && !streaming {
+		s.recordResponse(resp, upstream.recording)
+	}
+	if streaming {
+		if s.limits.streamIdle > 0 {
+			target := resp.Request.URL.Redacted()
+			resp.Body = newIdleTimeoutBody(resp.Body, s.limits.streamIdle, func() {
+				s.logger.Debugf(logMsgStreamIdle, target, s.limits.streamIdle)
+			})
+		}
+		return nil
+	}
+
+	limit := s.limits.maxResponseBytes
+	if limit <= 0 {
+		return nil
+	}
+	if resp.ContentLength > limit {
+		return fmt.Errorf("%w: Content-Length %d exceeds %d bytes", errProxyResponseTooLarge, resp.ContentLength, limit)
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, remaining: limit}
+	return nil
+}
+
+// handleProxyError writes the response for a request that could not be forwarded: 403 for
+// targets the guard rejected, 413 for oversized request bodies, 504 for upstream timeouts and
+// 502 for other upstream failures.
+func (s *Server) handleProxyError(w http.ResponseWriter, r *http.Request, err error) {
+	target := r.URL.Redacted()
+	if upstream, ok := r.Context().Value(proxyUpstreamKey{}).(proxyUpstream); ok {
+		target = upstream.url.Redacted()
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, errProxyAddressDenied):
+		s.logger.Warnf(logMsgProxyDenied, clientIP(r), target, err)
+		http.Error(w, errMsgProxyTargetDenied, http.StatusForbidden)
+	case errors.As(err, &maxBytesErr):
+		s.logAndSendError(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf(logMsgProxyUpstreamError, target, err), fmt.Sprintf(errMsgProxyRequestTooLarge, maxBytesErr.Limit))
+	case errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()):
+		s.logAndSendError(w, r, http.StatusGatewayTimeout, fmt.Sprintf(logMsgProxyUpstreamError, target, err), errMsgProxyUpstreamTimeout)
+	case errors.Is(err, context.Canceled):
+		// The client went away; there is nobody to respond to
+		s.logger.Debugf(logMsgProxyUpstreamError, target, err)
+	default:
+		s.logAndSendError(w, r, http.StatusBadGateway, fmt.Sprintf(logMsgProxyUpstreamError, target, err), errMsgProxyUpstreamFailed)
+	}
+}
+
+// upstreamTransport sends a proxied request with the client of its upstream, so that the
+// guard's address checks and the service proxy's credentials apply.
+type upstreamTransport struct{}
+
+// RoundTrip implements http.RoundTripper. Redirects are not followed but returned to the caller,
+// like any reverse proxy does, so that "Try it out" shows the actual response. This also keeps
+// injected credentials from following a redirect to another host.
+func (upstreamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	upstream := req.Context().Value(proxyUpstreamKey{}).(proxyUpstream)
+	client := *upstream.client
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return client.Do(req)
+}
+
+// limitedBody is a response body that fails once more than remaining bytes have been read.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+// Read implements io.Reader.
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, errProxyResponseTooLarge
+	}
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1] // Read one byte past the limit to detect overlong bodies
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n + int(b.remaining), errProxyResponseTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,435 @@
+package swagger
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/mail"
+	"net/netip"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Constants for schema validation
+const (
+	schemaMaxDepth = 64 // Nesting depth at which validation stops, guarding against pathological documents
+	schemaRefMax   = 32 // Length of $ref chains that are followed
+)
+
+// schemaDirection tells the validator which side of an exchange a value comes from, since
+// readOnly properties may be missing from requests and writeOnly ones from responses.
+type schemaDirection int
+
+const (
+	schemaRequest schemaDirection = iota
+	schemaResponse
+)
+
+// schemaViolation is one way in which a value doesn't match its schema.
+type schemaViolation struct {
+	Location string `json:"location"` // E.g. "query.limit" or "body.items[0].name"
+	Message  string `json:"message"`
+}
+
+// schemaValidator validates decoded JSON values against the schemas of one OpenAPI 3.x or
+// Swagger 2.0 document. It supports the commonly used subset of JSON Schema: types (including
+// nullable), enum/const, string, number, array and object constraints, common formats, and
+// allOf/anyOf/oneOf/not. Unknown keywords and formats are ignored.
+type schemaValidator struct {
+	spec      map[string]interface{} // Document that local $refs are resolved in
+	direction schemaDirection
+}
+
+// patternCache keeps compiled "pattern" regular expressions across requests.
+var patternCache sync.Map // map[string]*regexp.Regexp
+
+// resolveRef follows local $ref chains ("#/components/schemas/Pet") and returns the referenced
+// object, or node itself if it isn't a reference. Unresolvable references yield nil.
+func resolveRef(spec map[string]interface{}, node map[string]interface{}) map[string]interface{} {
+	for i := 0; i < schemaRefMax && node != nil; i++ {
+		ref, ok := node["$ref"].(string)
+		if !ok {
+			return node
+		}
+		node = lookupPointer(spec, ref)
+	}
+	return node
+}
+
+// lookupPointer returns the object at a local JSON pointer such as "#/definitions/Pet".
+func lookupPointer(spec map[string]interface{}, ref string) map[string]interface{} {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil // Only references within the document are supported
+	}
+	var current interface{} = spec
+	for _, token := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = object[token]
+	}
+	object, _ := current.(map[string]interface{})
+	return object
+}
+
+// validate returns the violations of value against schema. location names value in messages.
+func (v *schemaValidator) validate(schema map[string]interface{}, value interface{}, location string) []schemaViolation {
+	return v.validateDepth(schema, value, location, 0)
+}
+
+// validateDepth is validate at a nesting depth, which is bounded by schemaMaxDepth.
+func (v *schemaValidator) validateDepth(schema map[string]interface{}, value interface{}, location string, depth int) []schemaViolation {
+	schema = resolveRef(v.spec, schema)
+	if schema == nil || depth > schemaMaxDepth {
+		return nil
+	}
+	violation := func(format string, args ...interface{}) []schemaViolation {
+		return []schemaViolation{{Location: location, Message: fmt.Sprintf(format, args...)}}
+	}
+
+	var violations []schemaViolation
+	for _, sub := range schemaList(schema["allOf"]) {
+		violations = append(violations, v.validateDepth(sub, value, location, depth+1)...)
+	}
+	if anyOf := schemaList(schema["anyOf"]); len(anyOf) > 0 && v.countMatches(anyOf, value, location, depth) == 0 {
+		violations = append(violations, violation("does not match any of the anyOf schemas")...)
+	}
+	if oneOf := schemaList(schema["oneOf"]); len(oneOf) > 0 {
+		if matches := v.countMatches(oneOf, value, location, depth); matches != 1 {
+			violations = append(violations, violation("matches %d of the oneOf schemas, expected exactly 1", matches)...)
+		}
+	}
+	if not, ok := schema["not"].(map[string]interface{}); ok && len(v.validateDepth(not, value, location, depth+1)) == 0 {
+		violations = append(violations, violation("must not match the \"not\" schema")...)
+	}
+
+	if value == nil {
+		if schemaAllowsNull(schema) {
+			return violations
+		}
+		if types := schemaTypes(schema); len(types) > 0 {
+			return append(violations, violation("must not be null")...)
+		}
+	}
+
+	if types := schemaTypes(schema); len(types) > 0 && !matchesAnyType(value, types) {
+		return append(violations, violation("must be of type %s, got %s", strings.Join(types, " or "), jsonType(value))...)
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok && !containsValue(enum, value) {
+		violations = append(violations, violation("must be one of %s", compactJSON(enum))...)
+	}
+	if constant, ok := schema["const"]; ok && !reflect.DeepEqual(constant, value) {
+		violations = append(violations, violation("must be %s", compactJSON(constant))...)
+	}
+
+	switch typed := value.(type) {
+	case string:
+		violations = append(violations, validateString(schema, typed, violation)...)
+	case float64:
+		violations = append(violations, validateNumber(schema, typed, violation)...)
+	case []interface{}:
+		violations = append(violations, v.validateArray(schema, typed, location, depth, violation)...)
+	case map[string]interface{}:
+		violations = append(violations, v.validateObject(schema, typed, location, depth, violation)...)
+	}
+	return violations
+}
+
+// countMatches returns how many of the schemas value matches.
+func (v *schemaValidator) countMatches(schemas []map[string]interface{}, value interface{}, location string, depth int) int {
+	matches := 0
+	for _, sub := range schemas {
+		if len(v.validateDepth(sub, value, location, depth+1)) == 0 {
+			matches++
+		}
+	}
+	return matches
+}
+
+// validateString checks the string constraints of a schema.
+func validateString(schema map[string]interface{}, value string, violation func(string, ...interface{}) []schemaViolation) []schemaViolation {
+	var violations []schemaViolation
+	length := float64(len([]rune(value)))
+	if minLength, ok := schema["minLength"].(float64); ok && length < minLength {
+		violations = append(violations, violation("must be at least %g characters long", minLength)...)
+	}
+	if maxLength, ok := schema["maxLength"].(float64); ok && length > maxLength {
+		violations = append(violations, violation("must be at most %g characters long", maxLength)...)
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		if re := compilePattern(pattern); re != nil && !re.MatchString(value) {
+			violations = append(violations, violation("must match pattern %s", pattern)...)
+		}
+	}
+	if format, ok := schema["format"].(string); ok && !validFormat(format, value) {
+		violations = append(violations, violation("must be a valid %s", format)...)
+	}
+	return violations
+}
+
+// validateNumber checks the numeric constraints of a schema. exclusiveMinimum and
+// exclusiveMaximum may be booleans (OpenAPI 3.0, Swagger 2.0) or numbers (OpenAPI 3.1).
+func validateNumber(schema map[string]interface{}, value float64, violation func(string, ...interface{}) []schemaViolation) []schemaViolation {
+	var violations []schemaViolation
+	exclusiveMin, _ := schema["exclusiveMinimum"].(bool)
+	exclusiveMax, _ := schema["exclusiveMaximum"].(bool)
+	if minimum, ok := schema["minimum"].(float64); ok && (value < minimum || (exclusiveMin && value == minimum)) {
+		violations = append(violations, violation("must be %s %g", map[bool]string{false: ">=", true: ">"}[exclusiveMin], minimum)...)
+	}
+	if maximum, ok := schema["maximum"].(float64); ok && (value > maximum || (exclusiveMax && value == maximum)) {
+		violations = append(violations, violation("must be %s %g", map[bool]string{false: "<=", true: "<"}[exclusiveMax], maximum)...)
+	}
+	if minimum, ok := schema["exclusiveMinimum"].(float64); ok && value <= minimum {
+		violations = append(violations, violation("must be > %g", minimum)...)
+	}
+	if maximum, ok := schema["exclusiveMaximum"].(float64); ok && value >= maximum {
+		violations = append(violations, violation("must be < %g", maximum)...)
+	}
+	if multipleOf, ok := schema["multipleOf"].(float64); ok && multipleOf > 0 {
+		if quotient := value / multipleOf; math.Abs(quotient-math.Round(quotient)) > 1e-9 {
+			violations = append(violations, violation("must be a multiple of %g", multipleOf)...)
+		}
+	}
+	return violations
+}
+
+// validateArray checks the array constraints and items of a schema.
+func (v *schemaValidator) validateArray(schema map[string]interface{}, value []interface{}, location string, depth int, violation func(string, ...interface{}) []schemaViolation) []schemaViolation {
+	var violations []schemaViolation
+	if minItems, ok := schema["minItems"].(float64); ok && float64(len(value)) < minItems {
+		violations = append(violations, violation("must have at least %g items", minItems)...)
+	}
+	if maxItems, ok := schema["maxItems"].(float64); ok && float64(len(value)) > maxItems {
+		violations = append(violations, violation("must have at most %g items", maxItems)...)
+	}
+	if unique, _ := schema["uniqueItems"].(bool); unique {
+		// Items are compared by their JSON encoding, which sorts object keys; only the first
+		// duplicate is reported, so large arrays stay linear
+		seen := make(map[string]int, len(value))
+		for i, item := range value {
+			encoded, err := json.Marshal(item)
+			if err != nil {
+				continue
+			}
+			if j, ok := seen[string(encoded)]; ok {
+				violations = append(violations, violation("items %d and %d must be unique", j, i)...)
+				break
+			}
+			seen[string(encoded)] = i
+		}
+	}
+	if items, ok := schema["items"].(map[string]interface{}); ok {
+		for i, item := range value {
+			violations = append(violations, v.validateDepth(items, item, fmt.Sprintf("%s[%d]", location, i), depth+1)...)
+		}
+	}
+	return violations
+}
+
+// validateObject checks the required, properties and additionalProperties of a schema.
+func (v *schemaValidator) validateObject(schema map[string]interface{}, value map[string]interface{}, location string, depth int, violation func(string, ...interface{}) []schemaViolation) []schemaViolation {
+	var violations []schemaViolation
+	properties, _ := schema["properties"].(map[string]interface{})
+
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, name := range required {
+			nameStr, _ := name.(string)
+			if _, present := value[nameStr]; present || nameStr == "" {
+				continue
+			}
+			// readOnly properties are only sent by servers, writeOnly ones only by clients
+			property := resolveRef(v.spec, asObject(properties[nameStr]))
+			if readOnly, _ := property["readOnly"].(bool); readOnly && v.direction == schemaRequest {
+				continue
+			}
+			if writeOnly, _ := property["writeOnly"].(bool); writeOnly && v.direction == schemaResponse {
+				continue
+			}
+			violations = append(violations, schemaViolation{Location: joinLocation(location, nameStr), Message: "is required"})
+		}
+	}
+	if minProperties, ok := schema["minProperties"].(float64); ok && float64(len(value)) < minProperties {
+		violations = append(violations, violation("must have at least %g properties", minProperties)...)
+	}
+	if maxProperties, ok := schema["maxProperties"].(float64); ok && float64(len(value)) > maxProperties {
+		violations = append(violations, violation("must have at most %g properties", maxProperties)...)
+	}
+
+	for _, name := range sortedKeys(value) {
+		propertyValue := value[name]
+		if property, ok := properties[name].(map[string]interface{}); ok {
+			violations = append(violations, v.validateDepth(property, propertyValue, joinLocation(location, name), depth+1)...)
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				violations = append(violations, schemaViolation{Location: joinLocation(location, name), Message: "is not an allowed property"})
+			}
+		case map[string]interface{}:
+			violations = append(violations, v.validateDepth(additional, propertyValue, joinLocation(location, name), depth+1)...)
+		}
+	}
+	return violations
+}
+
+// schemaTypes returns the types a schema allows: "type" as a string or (OpenAPI 3.1) a list.
+func schemaTypes(schema map[string]interface{}) []string {
+	switch t := schema["type"].(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		var types []string
+		for _, item := range t {
+			if typeStr, ok := item.(string); ok {
+				types = append(types, typeStr)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+// schemaAllowsNull reports whether a schema accepts null: OpenAPI 3.0 "nullable", Swagger 2.0
+// "x-nullable", or a "null" type in OpenAPI 3.1.
+func schemaAllowsNull(schema map[string]interface{}) bool {
+	if nullable, _ := schema["nullable"].(bool); nullable {
+		return true
+	}
+	if nullable, _ := schema["x-nullable"].(bool); nullable {
+		return true
+	}
+	for _, t := range schemaTypes(schema) {
+		if t == "null" {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAnyType reports whether a decoded JSON value has one of the given schema types.
+func matchesAnyType(value interface{}, types []string) bool {
+	for _, t := range types {
+		switch typed := value.(type) {
+		case float64:
+			if t == "number" || (t == "integer" && typed == math.Trunc(typed)) {
+				return true
+			}
+		default:
+			if jsonType(value) == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jsonType returns the JSON Schema type name of a decoded JSON value.
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// validFormat checks the string formats that are commonly relied upon. Unknown formats pass.
+func validFormat(format, value string) bool {
+	var err error
+	switch format {
+	case "date-time":
+		_, err = time.Parse(time.RFC3339, value)
+	case "date":
+		_, err = time.Parse(time.DateOnly, value)
+	case "uuid":
+		return uuidPattern.MatchString(value)
+	case "email":
+		_, err = mail.ParseAddress(value)
+	case "ipv4":
+		var addr netip.Addr
+		addr, err = netip.ParseAddr(value)
+		return err == nil && addr.Is4()
+	case "ipv6":
+		var addr netip.Addr
+		addr, err = netip.ParseAddr(value)
+		return err == nil && addr.Is6()
+	}
+	return err == nil
+}
+
+// uuidPattern matches the textual form of a UUID.
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// compilePattern compiles and caches a schema pattern, or returns nil if it isn't valid RE2.
+func compilePattern(pattern string) *regexp.Regexp {
+	if cached, ok := patternCache.Load(pattern); ok {
+		re, _ := cached.(*regexp.Regexp)
+		return re
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		re = nil // ECMA-262 features RE2 doesn't support; the pattern is not checked
+	}
+	patternCache.Store(pattern, re)
+	return re
+}
+
+// schemaList returns the schema objects of an allOf/anyOf/oneOf list.
+func schemaList(value interface{}) []map[string]interface{} {
+	items, _ := value.([]interface{})
+	schemas := make([]map[string]interface{}, 0, len(items))
+	for _, item := range items {
+		if schema, ok := item.(map[string]interface{}); ok {
+			schemas = append(schemas, schema)
+		}
+	}
+	return schemas
+}
+
+// asObject returns value as a JSON object, or nil.
+func asObject(value interface{}) map[string]interface{} {
+	object, _ := value.(map[string]interface{})
+	return object
+}
+
+// containsValue reports whether list contains value.
+func containsValue(list []interface{}, value interface{}) bool {
+	for _, item := range list {
+		if reflect.DeepEqual(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// joinLocation appends a property name to a location.
+func joinLocation(location, name string) string {
+	if location == "" {
+		return name
+	}
+	return location + "." + name
+}
+
+// compactJSON formats a value for a violation message.
+func compactJSON(value interface{}) string {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(encoded)
+}
@@ -0,0 +1,114 @@
+package swagger
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestSchemaValidator(t *testing.T) {
+	spec := map[string]interface{}{}
+	mustUnmarshal(t, `{
+		"components": {"schemas": {
+			"Pet": {
+				"type": "object",
+				"required": ["name", "id"],
+				"properties": {
+					"id": {"type": "integer", "readOnly": true},
+					"name": {"type": "string", "minLength": 1},
+					"tag": {"type": "string", "nullable": true}
+				},
+				"additionalProperties": false
+			}
+		}}
+	}`, &spec)
+
+	tests := []struct {
+		name      string
+		schema    string
+		value     string
+		direction schemaDirection
+		want      []schemaViolation
+	}{
+		{name: "matching type", schema: `{"type": "string"}`, value: `"x"`},
+		{name: "wrong type", schema: `{"type": "integer"}`, value: `"x"`,
+			want: []schemaViolation{{Location: "body", Message: "must be of type integer, got string"}}},
+		{name: "integer accepts whole numbers", schema: `{"type": "integer"}`, value: `3.0`},
+		{name: "integer rejects fractions", schema: `{"type": "integer"}`, value: `3.5`,
+			want: []schemaViolation{{Location: "body", Message: "must be of type integer, got number"}}},
+		{name: "type list", schema: `{"type": ["string", "null"]}`, value: `null`},
+		{name: "null not allowed", schema: `{"type": "string"}`, value: `null`,
+			want: []schemaViolation{{Location: "body", Message: "must not be null"}}},
+		{name: "nullable", schema: `{"type": "string", "nullable": true}`, value: `null`},
+		{name: "enum", schema: `{"enum": ["a", "b"]}`, value: `"c"`,
+			want: []schemaViolation{{Location: "body", Message: `must be one of ["a","b"]`}}},
+		{name: "const", schema: `{"const": 1}`, value: `2`,
+			want: []schemaViolation{{Location: "body", Message: "must be 1"}}},
+		{name: "string length", schema: `{"type": "string", "minLength": 2, "maxLength": 3}`, value: `"ü"`,
+			want: []schemaViolation{{Location: "body", Message: "must be at least 2 characters long"}}},
+		{name: "pattern", schema: `{"type": "string", "pattern": "^[a-z]+$"}`, value: `"A1"`,
+			want: []schemaViolation{{Location: "body", Message: "must match pattern ^[a-z]+$"}}},
+		{name: "unsupported pattern is ignored", schema: `{"type": "string", "pattern": "(?<=a)b"}`, value: `"x"`},
+		{name: "format uuid", schema: `{"type": "string", "format": "uuid"}`, value: `"not-a-uuid"`,
+			want: []schemaViolation{{Location: "body", Message: "must be a valid uuid"}}},
+		{name: "format date-time", schema: `{"type": "string", "format": "date-time"}`, value: `"2024-01-02T03:04:05Z"`},
+		{name: "unknown format is ignored", schema: `{"type": "string", "format": "color"}`, value: `"x"`},
+		{name: "minimum", schema: `{"type": "number", "minimum": 1}`, value: `0`,
+			want: []schemaViolation{{Location: "body", Message: "must be >= 1"}}},
+		{name: "boolean exclusiveMaximum", schema: `{"type": "number", "maximum": 5, "exclusiveMaximum": true}`, value: `5`,
+			want: []schemaViolation{{Location: "body", Message: "must be < 5"}}},
+		{name: "numeric exclusiveMinimum", schema: `{"type": "number", "exclusiveMinimum": 0}`, value: `0`,
+			want: []schemaViolation{{Location: "body", Message: "must be > 0"}}},
+		{name: "multipleOf", schema: `{"type": "number", "multipleOf": 0.1}`, value: `0.3`},
+		{name: "not a multiple", schema: `{"type": "number", "multipleOf": 2}`, value: `3`,
+			want: []schemaViolation{{Location: "body", Message: "must be a multiple of 2"}}},
+		{name: "array items", schema: `{"type": "array", "items": {"type": "integer"}, "maxItems": 2}`, value: `[1, "x", 3]`,
+			want: []schemaViolation{
+				{Location: "body", Message: "must have at most 2 items"},
+				{Location: "body[1]", Message: "must be of type integer, got string"},
+			}},
+		{name: "uniqueItems reports the first duplicate", schema: `{"type": "array", "uniqueItems": true}`, value: `[{"a": 1, "b": 2}, 1, {"b": 2, "a": 1}, 1]`,
+			want: []schemaViolation{{Location: "body", Message: "items 0 and 2 must be unique"}}},
+		{name: "unique items", schema: `{"type": "array", "uniqueItems": true}`, value: `[1, "1", [1], {"1": 1}]`},
+		{name: "object", schema: `{"$ref": "#/components/schemas/Pet"}`, value: `{"id": 1, "name": "", "extra": true}`,
+			want: []schemaViolation{
+				{Location: "body.extra", Message: "is not an allowed property"},
+				{Location: "body.name", Message: "must be at least 1 characters long"},
+			}},
+		{name: "readOnly not required in requests", schema: `{"$ref": "#/components/schemas/Pet"}`, value: `{"name": "Rex", "tag": null}`},
+		{name: "readOnly required in responses", schema: `{"$ref": "#/components/schemas/Pet"}`, value: `{"name": "Rex"}`, direction: schemaResponse,
+			want: []schemaViolation{{Location: "body.id", Message: "is required"}}},
+		{name: "unresolvable ref is ignored", schema: `{"$ref": "#/components/schemas/Missing"}`, value: `1`},
+		{name: "additionalProperties schema", schema: `{"type": "object", "additionalProperties": {"type": "integer"}}`, value: `{"a": 1, "b": "x"}`,
+			want: []schemaViolation{{Location: "body.b", Message: "must be of type integer, got string"}}},
+		{name: "allOf", schema: `{"allOf": [{"type": "number"}, {"minimum": 10}]}`, value: `5`,
+			want: []schemaViolation{{Location: "body", Message: "must be >= 10"}}},
+		{name: "anyOf", schema: `{"anyOf": [{"type": "string"}, {"type": "boolean"}]}`, value: `1`,
+			want: []schemaViolation{{Location: "body", Message: "does not match any of the anyOf schemas"}}},
+		{name: "oneOf", schema: `{"oneOf": [{"type": "number"}, {"type": "integer"}]}`, value: `1`,
+			want: []schemaViolation{{Location: "body", Message: "matches 2 of the oneOf schemas, expected exactly 1"}}},
+		{name: "not", schema: `{"not": {"type": "string"}}`, value: `"x"`,
+			want: []schemaViolation{{Location: "body", Message: `must not match the "not" schema`}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var schema map[string]interface{}
+			var value interface{}
+			mustUnmarshal(t, tt.schema, &schema)
+			mustUnmarshal(t, tt.value, &value)
+
+			v := &schemaValidator{spec: spec, direction: tt.direction}
+			if got := v.validate(schema, value, "body"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("validate(%s, %s) = %v, want %v", tt.schema, tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+// mustUnmarshal decodes JSON into v or fails the test.
+func mustUnmarshal(t *testing.T, data string, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal([]byte(data), v); err != nil {
+		t.Fatalf("json.Unmarshal(%s): %v", data, err)
+	}
+}
@@ -2,17 +2,20 @@
 package swagger
 
 import (
-	"bytes" // Added for proxy request body handling
+	"context"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io" // Restored for proxy request body and response handling
 	"net/http"
+	"net/http/httputil"
 	"net/url"
 	"os"
 	"path/filepath" // Added for joining embed paths
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/sirupsen/logrus" // Added for structured logging
 )
@@ -29,8 +32,8 @@ const (
 
 // Constants for dev mode
 const (
-	devModeTrue                  = "true"
-	devModeLocalhostReplacement  = "http://localhost:8080"   // Replacement URL for local development
+	devModeTrue                 = "true"
+	devModeLocalhostReplacement = "http://localhost:8080" // Replacement URL for local development
 )
 
 // Constants for embedded file system paths
@@ -48,6 +51,7 @@ const (
 	httpPathAPI          = "/api/"          // Prefix for serving individual, processed API specs
 	httpPathProxy        = "/proxy/"        // Prefix for proxying requests
 	queryParamProxyURL   = "proxyUrl"
+	queryParamCluster    = "cluster" // Cluster whose connection a proxied request is routed through
 )
 
 // Constants for HTTP headers and values
@@ -69,8 +73,8 @@ const (
 	cacheControlPublicMaxAge3600 = "public, max-age=3600"
 	corsAllowOriginAll           = "*"
 	corsAllowMethodsDefault      = "GET, POST, OPTIONS"
-	corsAllowHeadersDefault      = "Content-Type, Authorization"
-	corsExposeHeadersDefault     = "Content-Length"
+	corsAllowHeadersDefault      = "Content-Type, Authorization, Prefer, X-Fault-Injection"
+	corsExposeHeadersDefault     = "Content-Length, X-Contract-Check, Preference-Applied, X-Fault-Injected"
 )
 
 // Constants for error messages and log formats
@@ -99,31 +103,137 @@ const (
 	errMsgFailedToEncodeModifiedSpec = "Failed to encode modified spec"
 	errMsgProxyURLRequired           = "proxyUrl query parameter is required for all requests"
 	errMsgFailedToCreateProxyReq     = "Failed to create proxy request: %v"
-	errMsgFailedToForwardProxyReq    = "Failed to forward request: %v"
 	errMsgStaticFileNotFound         = "Static file not found"
 )
 
 // APIMetadata represents metadata about an OpenAPI specification
 type APIMetadata struct {
-	Name           string   `json:"name"`           // API name
-	URL            string   `json:"url"`            // URL to fetch the OpenAPI spec
-	Title          string   `json:"title"`          // Display title
-	Version        string   `json:"version"`        // API version
-	Description    string   `json:"description"`    // API description
-	ResourceType   string   `json:"resourceType"`   // Type of resource (e.g., Service, Deployment)
-	ResourceName   string   `json:"resourceName"`   // Name of the Kubernetes resource
-	Namespace      string   `json:"namespace"`      // Kubernetes namespace
-	LastUpdated    string   `json:"lastUpdated"`    // Last update timestamp
-	AllowedMethods []string `json:"allowedMethods"` // Allowed HTTP methods for Swagger UI
+	Name           string   `json:"name"`              // API name
+	URL            string   `json:"url"`               // URL to fetch the OpenAPI spec
+	Title          string   `json:"title"`             // Display title
+	Version        string   `json:"version"`           // API version
+	Description    string   `json:"description"`       // API description
+	ResourceType   string   `json:"resourceType"`      // Type of resource (e.g., Service, Deployment)
+	ResourceName   string   `json:"resourceName"`      // Name of the Kubernetes resource
+	Namespace      string   `json:"namespace"`         // Kubernetes namespace
+	LastUpdated    string   `json:"lastUpdated"`       // Last update timestamp
+	AllowedMethods []string `json:"allowedMethods"`    // Allowed HTTP methods for Swagger UI
+	Cluster        string   `json:"cluster,omitempty"` // Cluster the API was discovered in (multi-cluster mode)
 	Error          string   `json:"error,omitempty"`
+
+	// AutoProbe makes URL a base URL (or, if URL is empty, derives it from a Service resourceName
+	// and namespace) under which well-known spec locations of common frameworks are probed.
+	AutoProbe bool `json:"autoProbe,omitempty"`
+
+	// SwaggerConfigURL points to a Swagger UI configuration (such as springdoc's
+	// /v3/api-docs/swagger-config) or a bare "urls" list. Each listed group becomes a child API
+	// named "<name>:<group>". A relative value is resolved against URL.
+	SwaggerConfigURL string `json:"swaggerConfigUrl,omitempty"`
+	Parent           string `json:"parent,omitempty"` // Name of the API a group was expanded from
+	Group            string `json:"group,omitempty"`  // Group name within the parent API
+
+	// Credentials, if set, are attached by the proxy to requests to this API. They are never
+	// sent to the browser; /swagger-specs only shows the header and where the value comes from.
+	Credentials *APICredentials `json:"credentials,omitempty"`
+
+	// HeaderPolicy adjusts which request and response headers the proxy passes for this API.
+	HeaderPolicy *HeaderPolicy `json:"headerPolicy,omitempty"`
+
+	// RateLimit overrides the default rate limit of proxied requests to this API.
+	RateLimit *APIRateLimit `json:"rateLimit,omitempty"`
+
+	// ValidateRequests overrides PROXY_VALIDATE_REQUESTS for this API.
+	ValidateRequests *bool `json:"validateRequests,omitempty"`
+
+	// CheckContract overrides PROXY_CHECK_CONTRACT for this API.
+	CheckContract *bool `json:"checkContract,omitempty"`
+
+	// TryItOut is where Swagger UI's "Try it out" requests go: "proxy" (the real server, through
+	// the proxy) or "mock" (the mock server). It overrides TRY_IT_OUT_TARGET.
+	TryItOut string `json:"tryItOut,omitempty"`
+
+	// Faults configures fault injection into proxied requests to this API. It only takes effect
+	// when PROXY_FAULT_INJECTION is enabled.
+	Faults *FaultConfig `json:"faults,omitempty"`
+
+	// RecordExamples overrides PROXY_RECORD_EXAMPLES for this API.
+	RecordExamples *bool `json:"recordExamples,omitempty"`
+
+	// MergeRecordedExamples overrides MERGE_RECORDED_EXAMPLES for this API.
+	MergeRecordedExamples *bool `json:"mergeRecordedExamples,omitempty"`
+
+	// BaseURL, if set, replaces the servers of the spec: requests are sent to it, and the proxy
+	// only allows it and the spec URL's origin.
+	BaseURL string `json:"baseUrl,omitempty"`
+
+	// AllowedHosts are host glob patterns (such as "api.example.com" or "*.orders.svc:8080") of
+	// servers declared in the spec that the proxy may reach, besides the hosts of URL and BaseURL.
+	AllowedHosts []string `json:"allowedHosts,omitempty"`
+
+	// Environments lists deployments of the API (such as dev, staging and prod) with their own
+	// spec URL and base URL. The API is listed once; /api/{name}?env=<environment> serves the spec
+	// of an environment, and "Try it out" requests only reach that environment's servers.
+	Environments       []APIEnvironment `json:"environments,omitempty"`
+	DefaultEnvironment string           `json:"defaultEnvironment,omitempty"` // Defaults to the first environment
+	Environment        string           `json:"environment,omitempty"`        // Environment this entry describes
+
+	// Fetch, if set, produces the OpenAPI document instead of fetching URL. It is used for
+	// documents that need credentials or are generated, such as Kubernetes API groups and CRDs.
+	Fetch SpecFetcher `json:"-"`
+}
+
+// SpecFetcher returns a freshly built or fetched OpenAPI document. Callers may modify the result.
+type SpecFetcher func() (map[string]interface{}, error)
+
+// specKey returns the key under which an API is registered. APIs discovered in a named
+// cluster are keyed as name@cluster so that equally named APIs in different clusters coexist.
+func specKey(api APIMetadata) string {
+	if api.Cluster == "" {
+		return api.Name
+	}
+	return api.Name + "@" + api.Cluster
 }
 
 // Server serves the Swagger UI and aggregated OpenAPI specs
 type Server struct {
-	specs    map[string]APIMetadata // Map of API name to metadata
+	specs    map[string]APIMetadata // Map of API key (see specKey) to metadata
 	specsMux sync.RWMutex           // Mutex for thread-safe access to specs
 	basePath string                 // Base path for the server (for Ingress/Route support), e.g., /swagger
 	logger   *logrus.Logger         // Added for structured logging
+
+	serviceProxies    map[string]*ServiceProxy // API server service proxies by cluster name, for out-of-cluster operation
+	serviceProxiesMux sync.RWMutex             // Mutex for thread-safe access to serviceProxies
+
+	probes    probeCache  // Spec locations discovered by auto-probing
+	guard     *proxyGuard // Decides which targets the proxy may reach
+	specCache specCache   // Recently fetched documents, for request-time operation lookups
+
+	credentials credentialStore // Secret values and OAuth2 tokens for credentials the proxy injects
+
+	enforceOperations bool                   // Only proxy requests that match an operation of the target API's spec
+	validateRequests  bool                   // Check proxied requests against their operation before forwarding
+	checkContract     bool                   // Check proxied responses against their operation's documented responses
+	contractMaxBody   int64                  // Largest response body checked against its contract
+	contracts         contractStats          // Conformance statistics of checked responses
+	coverageEnabled   bool                   // Count proxied calls by operation (PROXY_COVERAGE)
+	coverage          coverageStats          // Proxied calls by operation, for coverage reports
+	tryItOutDefault   string                 // Default target of "Try it out" requests (TRY_IT_OUT_TARGET)
+	faultInjection    bool                   // Inject configured and requested faults into proxied requests
+	recordExamples    bool                   // Record proxied calls as candidate spec examples
+	mergeRecorded     bool                   // Add recorded examples to served specs
+	recorder          *exampleRecorder       // Recent recordings of proxied calls
+	limits            proxyLimits            // Size and time limits of proxied requests
+	headerPolicy      proxyHeaderPolicy      // Server-wide rules for headers passed by the proxy
+	rateLimits        *rateLimiters          // Token buckets of proxied requests
+	identityHeader    string                 // Header identifying the user, if set by an authenticating proxy
+	metrics           *serverMetrics         // Counters exposed at /metrics
+	audit             *auditLog              // Records proxied calls; nil if auditing is disabled
+	draining          atomic.Bool            // Set once shutdown has started; /readyz then fails
+	discovery         discoveryState         // Discovery rounds reported by ReportDiscovery, for /readyz
+	readinessStale    time.Duration          // Longest time since Kubernetes was reached for /readyz to succeed
+	readinessMinSpecs int                    // Specs that must be loaded for /readyz to succeed
+	startedAt         time.Time              // When the server was created
+	reverseProxy      *httputil.ReverseProxy // Forwards proxied requests to their upstream
 }
 
 // NewServer creates a new Swagger UI server
@@ -148,15 +258,65 @@ func NewServer() *Server {
 		logger.Debug("DEV_MODE enabled, setting log level to DEBUG")
 	}
 
-	return &Server{
-		specs:    make(map[string]APIMetadata),
-		basePath: os.Getenv(envVarSwaggerBasePath),
-		logger:   logger,
-	}
+	s := &Server{
+		specs:          make(map[string]APIMetadata),
+		basePath:       os.Getenv(envVarSwaggerBasePath),
+		logger:         logger,
+		serviceProxies: make(map[string]*ServiceProxy),
+		probes:         probeCache{results: make(map[string]probeResult)},
+		specCache:      specCache{entries: make(map[string]cachedSpec), inflight: make(map[string]*specFetch)},
+		credentials: credentialStore{
+			readers: make(map[string]SecretReader),
+			secrets: make(map[string]cachedSecretValue),
+			tokens:  make(map[string]cachedTokenSource),
+		},
+
+		enforceOperations: os.Getenv(envVarProxyEnforceOps) == "true",
+		validateRequests:  os.Getenv(envVarProxyValidateRequests) == "true",
+		checkContract:     os.Getenv(envVarProxyCheckContract) == "true",
+		contracts:         contractStats{apis: make(map[string]*apiContractStats)},
+		coverageEnabled:   os.Getenv(envVarProxyCoverage) == "true",
+		coverage:          coverageStats{apis: make(map[string]*apiTraffic)},
+		tryItOutDefault:   os.Getenv(envVarTryItOutTarget),
+		faultInjection:    os.Getenv(envVarProxyFaultInjection) == "true",
+		recordExamples:    os.Getenv(envVarProxyRecordExamples) == "true",
+		mergeRecorded:     os.Getenv(envVarMergeRecordedExamples) == "true",
+		identityHeader:    os.Getenv(envVarProxyClientIdentityHeader),
+		metrics:           newServerMetrics(),
+		discovery:         discoveryState{clusters: make(map[string]*clusterDiscovery)},
+		startedAt:         time.Now().UTC(),
+	}
+	s.limits = s.loadProxyLimits()
+	s.contractMaxBody = int64(s.getEnvInt(envVarProxyContractMaxBodyBytes, defaultContractMaxBodyBytes))
+	s.recorder = s.newExampleRecorder()
+	s.readinessStale = time.Duration(s.getEnvInt(envVarReadinessStaleness, defaultReadinessStaleness)) * time.Second
+	s.readinessMinSpecs = s.getEnvInt(envVarReadinessMinSpecs, 0)
+	s.headerPolicy = loadHeaderPolicy()
+	s.rateLimits = s.loadRateLimiters()
+	s.audit = s.newAuditLog()
+	s.guard = s.newProxyGuard()
+	s.reverseProxy = s.newReverseProxy()
+	return s
+}
+
+// SetServiceProxy configures the server to reach in-cluster Service URLs of the named cluster
+// through that cluster's Kubernetes API server service proxy, both for fetching specs and for
+// proxied requests. The empty cluster name applies to APIs without a cluster.
+func (s *Server) SetServiceProxy(cluster string, p *ServiceProxy) {
+	s.serviceProxiesMux.Lock()
+	defer s.serviceProxiesMux.Unlock()
+	s.serviceProxies[cluster] = p
 }
 
-// UpdateSpecs updates the stored OpenAPI specs based on the current status
-func (s *Server) UpdateSpecs(apis []APIMetadata) {
+// UpdateSpecs updates the stored OpenAPI specs based on the current status. Discovery requests
+// are canceled when ctx is done, in which case the stored specs are left unchanged.
+func (s *Server) UpdateSpecs(ctx context.Context, apis []APIMetadata) {
+	// Resolve discovery options such as auto-probing before taking the lock, as they make network calls
+	apis = s.expandSpecs(ctx, apis)
+	if ctx.Err() != nil {
+		return // Incomplete: APIs whose discovery was canceled would be dropped
+	}
+
 	s.specsMux.Lock()
 	defer s.specsMux.Unlock()
 
@@ -169,20 +329,43 @@ func (s *Server) UpdateSpecs(apis []APIMetadata) {
 		}
 
 		metadata := APIMetadata{
-			Name:           api.Name,
-			URL:            api.URL,
-			Title:          api.Name,
-			Description:    fmt.Sprintf("API from %s/%s", api.Namespace, api.ResourceName),
-			ResourceType:   api.ResourceType,
-			ResourceName:   api.ResourceName,
-			Namespace:      api.Namespace,
-			LastUpdated:    api.LastUpdated,
-			AllowedMethods: api.AllowedMethods,
+			Name:                  api.Name,
+			URL:                   api.URL,
+			Title:                 api.Name,
+			Description:           fmt.Sprintf("API from %s/%s", api.Namespace, api.ResourceName),
+			ResourceType:          api.ResourceType,
+			ResourceName:          api.ResourceName,
+			Namespace:             api.Namespace,
+			LastUpdated:           api.LastUpdated,
+			AllowedMethods:        api.AllowedMethods,
+			Cluster:               api.Cluster,
+			Fetch:                 api.Fetch,
+			Parent:                api.Parent,
+			Group:                 api.Group,
+			Credentials:           api.Credentials,
+			HeaderPolicy:          api.HeaderPolicy,
+			RateLimit:             api.RateLimit,
+			ValidateRequests:      api.ValidateRequests,
+			CheckContract:         api.CheckContract,
+			TryItOut:              api.TryItOut,
+			Faults:                api.Faults,
+			RecordExamples:        api.RecordExamples,
+			MergeRecordedExamples: api.MergeRecordedExamples,
+			BaseURL:               api.BaseURL,
+			AllowedHosts:          api.AllowedHosts,
+			Environments:          api.Environments,
+			DefaultEnvironment:    api.DefaultEnvironment,
+			Environment:           api.Environment,
 		}
 
-		newSpecs[api.Name] = metadata
+		newSpecs[specKey(api)] = metadata
 	}
 	s.specs = newSpecs
+	s.guard.setAPIs(newSpecs)
+	s.credentials.forgetCredentials(newSpecs)
+	s.recorder.forgetExamples(newSpecs)
+	s.coverage.forgetCoverage(newSpecs)
+	s.contracts.forgetContractStats(newSpecs)
 	s.logger.Infof(logMsgAPISpecsUpdated, len(s.specs))
 }
 
@@ -227,16 +410,41 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		s.serveIndex(w, r)
 	case path == httpPathSwaggerSpecs:
 		s.serveSpecs(w, r)
+	case path == httpPathHealthz || r.URL.Path == httpPathHealthz: // Probes don't depend on SWAGGER_BASE_PATH
+		s.serveHealthz(w, r)
+	case path == httpPathReadyz || r.URL.Path == httpPathReadyz:
+		s.serveReadyz(w, r)
+	case path == httpPathMetrics:
+		s.serveMetrics(w, r)
+	case path == httpPathContractStats:
+		s.serveContractStats(w, r)
+	case path == httpPathCoverage:
+		s.serveCoverage(w, r)
+	case path == httpPathSpecDiff:
+		s.serveSpecDiff(w, r)
+	case strings.HasPrefix(path, httpPathAPI) && strings.HasSuffix(path, pathSuffixRecordedExamples):
+		s.serveRecordedExamples(w, r)
 	case strings.HasPrefix(path, httpPathAPI):
 		s.serveIndividualSpec(w, r)
 	case strings.HasPrefix(path, httpPathProxy):
 		s.proxyRequest(w, r)
+	case strings.HasPrefix(path, httpPathMock):
+		s.serveMock(w, r)
 	default:
 		// Assume it's a request for a static file from the embedded swagger-ui
 		s.serveStaticFiles(w, r)
 	}
 }
 
+// writeJSON sends v as a JSON response with the given status code.
+func (s *Server) writeJSON(w http.ResponseWriter, r *http.Request, statusCode int, v interface{}) {
+	w.Header().Set(headerContentType, contentTypeJSON)
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.logger.Errorf("[%s %s] HTTP %d - %s: %v", r.Method, r.URL.Path, statusCode, errMsgFailedToWriteResponse, err)
+	}
+}
+
 // serveIndex serves the Swagger UI index page
 func (s *Server) serveIndex(w http.ResponseWriter, r *http.Request) {
 	// Construct path to embedded index.html
@@ -269,8 +477,16 @@ func (s *Server) serveSpecs(w http.ResponseWriter, r *http.Request) {
 	s.specsMux.RLock()
 	defer s.specsMux.RUnlock()
 
+	// Environments other than the default are selected on their API instead of being listed
+	listed := make(map[string]APIMetadata, len(s.specs))
+	for key, metadata := range s.specs {
+		if !isEnvironmentEntry(metadata) {
+			listed[key] = metadata
+		}
+	}
+
 	w.Header().Set(headerContentType, contentTypeJSON)
-	if err := json.NewEncoder(w).Encode(s.specs); err != nil {
+	if err := json.NewEncoder(w).Encode(listed); err != nil {
 		// Use a formatted internal message
 		s.logAndSendError(w, r, http.StatusInternalServerError, fmt.Sprintf("%s: %v", errMsgFailedToEncodeSpecs, err), errMsgFailedToEncodeSpecs)
 	}
@@ -290,34 +506,12 @@ func (s *Server) serveIndividualSpec(w http.ResponseWriter, r *http.Request) {
 		s.logAndSendError(w, r, http.StatusNotFound, fmt.Sprintf("%s: %s", errMsgAPINotFound, apiName), errMsgAPINotFound)
 		return
 	}
-
-	// Fetch the spec in real-time
-	urlStr := metadata.URL
-	if os.Getenv(envVarDevMode) == devModeTrue {
-		// In development mode, rewrite any cluster URLs to localhost for easier local testing
-		// Example: if you wanted to replace all http with https in dev mode
-		// if strings.HasPrefix(urlStr, "http://") {
-		//  originalURL := urlStr
-		//  urlStr = strings.Replace(urlStr, "http://", "https://", 1)
-		//  s.logger.Debugf("DEV_MODE: Rewriting URL %s to %s", originalURL, urlStr)
-		// }
-	}
-	resp, err := http.Get(urlStr)
-	if err != nil {
-		s.logAndSendError(w, r, http.StatusInternalServerError, fmt.Sprintf(errMsgFailedToFetchSpec, err), fmt.Sprintf(errMsgFailedToFetchSpec, err))
-		return
-	}
-	if resp != nil {
-		defer func() {
-			if closeErr := resp.Body.Close(); closeErr != nil {
-				s.logger.Warnf(logErrFailedToCloseResponseBody, closeErr)
-			}
-		}()
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		s.logAndSendError(w, r, resp.StatusCode, fmt.Sprintf(errMsgFailedToFetchSpecStatus, resp.StatusCode), fmt.Sprintf(errMsgFailedToFetchSpecStatus, resp.StatusCode))
-		return
+	if environment := r.URL.Query().Get(queryParamEnvironment); environment != "" {
+		apiName, metadata, exists = s.environmentEntry(apiName, metadata, environment)
+		if !exists {
+			s.logAndSendError(w, r, http.StatusNotFound, fmt.Sprintf("%s: %s", errMsgEnvironmentNotFound, environment), errMsgEnvironmentNotFound)
+			return
+		}
 	}
 
 	// Parse metadata URL to get the server URL for potential spec modification
@@ -327,16 +521,33 @@ func (s *Server) serveIndividualSpec(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Read and parse the OpenAPI/Swagger spec
-	var spec map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&spec); err != nil {
-		s.logAndSendError(w, r, http.StatusInternalServerError, fmt.Sprintf(errMsgFailedToDecodeOpenAPISpec, err), fmt.Sprintf(errMsgFailedToDecodeOpenAPISpec, err))
+	spec, statusCode, err := s.fetchSpec(metadata)
+	if err != nil {
+		s.logAndSendError(w, r, statusCode, err.Error(), err.Error())
 		return
 	}
 
+	s.storeCachedSpec(apiName, spec)
+
 	// Update spec server info (e.g., host, servers array) based on OpenAPI/Swagger version
 	s.logger.Debugf(logMsgUpdatingSpecServerInfo, apiName)
 	s.updateSpecServerInfo(spec, metadataURL)
+	applyBaseURL(spec, metadata.BaseURL)
+	s.guard.learnServers(apiName, spec)
+	if s.shouldMergeRecordedExamples(metadata) {
+		s.mergeRecordedExamples(spec, apiName)
+	}
+
+	// Point "Try it out" at the path-based proxy route, so upstream hosts stay on the server side,
+	// or at the mock server. Documentation-only APIs (e.g. the Kubernetes API) otherwise keep their servers.
+	target := s.tryItOutTarget(r, metadata)
+	switch {
+	case target == tryItOutTargetMock:
+		rewriteSpecServers(spec, r, s.basePath+mockRoutePath(apiName))
+	case metadata.Fetch == nil:
+		s.rewriteSpecServersToProxy(spec, r, apiName, metadata)
+	}
+	w.Header().Set(headerTryItOutTarget, target)
 
 	w.Header().Set(headerContentType, contentTypeJSON)
 	if err := json.NewEncoder(w).Encode(spec); err != nil {
@@ -345,6 +556,47 @@ func (s *Server) serveIndividualSpec(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// fetchSpec fetches and decodes the OpenAPI document of an API in real-time, either from its
+// Fetch function or from its URL (through the API server service proxy if configured).
+// On failure it also returns the HTTP status code to report to the client.
+func (s *Server) fetchSpec(metadata APIMetadata) (map[string]interface{}, int, error) {
+	if metadata.Fetch != nil {
+		spec, err := metadata.Fetch()
+		if err != nil {
+			return nil, http.StatusInternalServerError, fmt.Errorf(errMsgFailedToFetchSpec, err)
+		}
+		return spec, http.StatusOK, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), specFetchTimeout)
+	defer cancel()
+	urlStr, client := s.resolveUpstream(metadata.Cluster, metadata.URL, http.DefaultClient)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, urlStr, nil)
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf(errMsgFailedToFetchSpec, err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf(errMsgFailedToFetchSpec, err)
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			s.logger.Warnf(logErrFailedToCloseResponseBody, closeErr)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode, fmt.Errorf(errMsgFailedToFetchSpecStatus, resp.StatusCode)
+	}
+
+	// Read and parse the OpenAPI/Swagger spec
+	var spec map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&spec); err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf(errMsgFailedToDecodeOpenAPISpec, err)
+	}
+	return spec, http.StatusOK, nil
+}
+
 // serveStaticFiles serves embedded static files (CSS, JS, images) for the Swagger UI.
 func (s *Server) serveStaticFiles(w http.ResponseWriter, r *http.Request) {
 	// Path relative to the swaggerUIEmbedRoot, after stripping any server basePath
@@ -391,75 +643,115 @@ func (s *Server) getContentType(path string) string {
 	}
 }
 
-// proxyRequest handles proxying requests to backend services.
-// It requires a 'proxyUrl' query parameter specifying the target URL.
+// proxyRequest handles proxying requests to backend services. Two forms are supported:
+//   - /proxy/{namespace}/{api}/{path...}: the upstream is resolved from the API's metadata and
+//     spec servers. Served specs point Swagger UI at these routes.
+//   - /proxy/?proxyUrl=<url>[&cluster=<name>]: the target URL is given explicitly, and an optional
+//     'cluster' query parameter selects the cluster connection to route the request through.
 func (s *Server) proxyRequest(w http.ResponseWriter, r *http.Request) {
+	w, r, audit := s.beginAudit(w, r)
+	defer s.finishAudit(audit)
+
+	var target *proxyTarget
+	if route := strings.TrimPrefix(s.stripBasePath(r.URL.EscapedPath()), httpPathProxy); route != "" {
+		target = s.resolveRouteTarget(w, r, route)
+	} else {
+		target = s.resolveQueryTarget(w, r)
+	}
+	if target == nil {
+		return // An error response has been sent
+	}
+	if audit != nil {
+		audit.target = target
+	}
+
+	// Requests to a registered API are limited to its allowed methods (and optionally its operations)
+	if target.class == proxyTargetRegistered {
+		if denial := s.authorizeOperation(target.apiKey, r.Method, target.url); denial != nil {
+			s.logger.Warnf(logMsgProxyDenied, clientIP(r), target.url.Redacted(), denial.Error)
+			s.writeJSON(w, r, http.StatusForbidden, denial)
+			return
+		}
+		if !s.validateProxyRequest(w, r, target) {
+			return // Invalid; a 400 response has been sent
+		}
+	}
+	if !s.allowRate(w, r, target) {
+		return // Throttled; a 429 response has been sent
+	}
+	r, ok := s.injectFaults(w, r, target)
+	if !ok {
+		return // Answered with an injected fault
+	}
+	s.trackCoverage(r, target)
+
+	s.forwardProxyRequest(w, r, target)
+}
+
+// resolveQueryTarget resolves a /proxy/?proxyUrl=<url> request. Only registered APIs and
+// allowlisted hosts may be reached, so the proxy can't be used to reach arbitrary hosts inside
+// the cluster. On failure an error response is sent and nil is returned.
+func (s *Server) resolveQueryTarget(w http.ResponseWriter, r *http.Request) *proxyTarget {
 	targetProxyURL := r.URL.Query().Get(queryParamProxyURL)
 	if targetProxyURL == "" {
 		s.logAndSendError(w, r, http.StatusBadRequest, errMsgProxyURLRequired, errMsgProxyURLRequired)
-		return
+		return nil
 	}
 
-	finalTargetURL := targetProxyURL
-
-	if os.Getenv(envVarDevMode) == devModeTrue {
-		// REMOVED: devModeClusterInternalSuffix logic
-		// Example: if you wanted to ensure all proxy requests go to localhost in dev mode
-		// if !strings.HasPrefix(finalTargetURL, "http://localhost") && !strings.HasPrefix(finalTargetURL, "https://localhost") {
-		//  s.logger.Debugf("DEV_MODE: Potentially redirecting proxy for %s to a localhost equivalent if applicable", finalTargetURL)
-		// }
+	cluster := r.URL.Query().Get(queryParamCluster)
+	targetURL, err := url.Parse(targetProxyURL)
+	if err != nil {
+		s.logAndSendError(w, r, http.StatusBadRequest, fmt.Sprintf(errMsgFailedToCreateProxyReq, err), fmt.Sprintf(errMsgFailedToCreateProxyReq, err))
+		return nil
 	}
-	s.logger.Debugf(logMsgProxyingRequestTo, r.URL.Path, finalTargetURL)
-
-	var reqBodyReader io.Reader
-	if r.Body != nil {
-		bodyBytes, err := io.ReadAll(r.Body)
-		if err != nil {
-			// Use a formatted internal message
-			s.logAndSendError(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to read request body for proxy: %v", err), "Failed to read request body")
-			return
-		}
-		r.Body.Close()                                    // Close original body after reading
-		r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes)) // Allow re-reading of original body if necessary elsewhere
-		reqBodyReader = bytes.NewBuffer(bodyBytes)        // Use a new buffer for the proxy request
+	class, apiKey, reason := s.guard.classify(cluster, targetURL)
+	if class == proxyTargetDenied {
+		s.logger.Warnf(logMsgProxyDenied, clientIP(r), targetURL.Redacted(), reason)
+		http.Error(w, errMsgProxyTargetDenied, http.StatusForbidden)
+		return nil
 	}
+	return &proxyTarget{cluster: cluster, url: targetURL, class: class, apiKey: apiKey}
+}
+
+// forwardProxyRequest streams the request to the resolved target and the response back.
+// Request bodies larger than PROXY_MAX_REQUEST_BYTES are rejected with 413.
+func (s *Server) forwardProxyRequest(w http.ResponseWriter, r *http.Request, target *proxyTarget) {
+	finalTargetURL, client := s.resolveUpstream(target.cluster, target.url.String(), s.guard.clientFor(target.class))
+	s.logger.Debugf(logMsgProxyingRequestTo, r.URL.Path, finalTargetURL)
 
-	proxyReq, err := http.NewRequest(r.Method, finalTargetURL, reqBodyReader)
+	upstreamURL, err := url.Parse(finalTargetURL)
 	if err != nil {
 		s.logAndSendError(w, r, http.StatusInternalServerError, fmt.Sprintf(errMsgFailedToCreateProxyReq, err), fmt.Sprintf(errMsgFailedToCreateProxyReq, err))
 		return
 	}
 
-	for name, values := range r.Header {
-		for _, value := range values {
-			proxyReq.Header.Add(name, value)
+	if limit := s.limits.maxRequestBytes; limit > 0 {
+		if r.ContentLength > limit {
+			s.logAndSendError(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf(errMsgProxyRequestTooLarge, limit), fmt.Sprintf(errMsgProxyRequestTooLarge, limit))
+			return
 		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
 	}
 
-	client := &http.Client{}
-	resp, err := client.Do(proxyReq)
-	if err != nil {
-		s.logger.Errorf("Error forwarding proxy request: %v", err) // Log internal error before sending generic response
-		s.logAndSendError(w, r, http.StatusInternalServerError, fmt.Sprintf(errMsgFailedToForwardProxyReq, err), fmt.Sprintf(errMsgFailedToForwardProxyReq, err))
-		return
-	}
-	defer func() {
-		if closeErr := resp.Body.Close(); closeErr != nil {
-			s.logger.Warnf(logErrFailedToCloseResponseBody, closeErr)
+	_, portalHost := requestOrigin(r)
+	upstream := proxyUpstream{url: upstreamURL, client: client, portalHost: portalHost}
+	if target.class == proxyTargetRegistered {
+		// Credentials of a registered API are added on the server side only
+		if upstream.credentials, err = s.credentialHeader(r.Context(), target.apiKey); err != nil {
+			s.logAndSendError(w, r, http.StatusBadGateway, fmt.Sprintf(logMsgCredentialsFailed, target.apiKey, err), errMsgCredentialsFailed)
+			return
 		}
-	}()
-
-	for name, values := range resp.Header {
-		for _, value := range values {
-			w.Header().Add(name, value)
+		s.specsMux.RLock()
+		if policy := s.specs[target.apiKey].HeaderPolicy; policy != nil {
+			upstream.headerPolicy = *policy
 		}
+		s.specsMux.RUnlock()
+		upstream.contract = s.prepareContractCheck(r, target)
+		upstream.recording = s.prepareRecording(r, target)
 	}
 
-	w.WriteHeader(resp.StatusCode)
-
-	if _, err := io.Copy(w, resp.Body); err != nil {
-		s.logger.Errorf("[%s %s] HTTP %d - Error copying response body: %v", r.Method, r.URL.Path, http.StatusInternalServerError, err)
-	}
+	ctx := context.WithValue(r.Context(), proxyUpstreamKey{}, upstream)
+	s.reverseProxy.ServeHTTP(w, r.WithContext(ctx))
 }
 
 // makeServerURL constructs a full URL from a base metadataURL and a pathComponent.
@@ -537,8 +829,15 @@ func (s *Server) updateSpecServerInfo(spec map[string]interface{}, metadataURL *
 	}
 }
 
-// Start starts the Swagger UI server on the specified port.
+// Start starts the Swagger UI server on the specified port. It only returns on failure;
+// use Run to shut down gracefully.
 func (s *Server) Start(port int) error {
+	return s.Run(context.Background(), port)
+}
+
+// newHTTPServers creates the HTTP server on the specified port and, when serving HTTPS with
+// TLS_HTTP_REDIRECT_PORT set, the plain-HTTP redirect server.
+func (s *Server) newHTTPServers(port int) ([]*listeningServer, error) {
 	// Use a new ServeMux for routing.
 	// The main ServeHTTP method of the Server struct will handle all requests to "/".
 	mux := http.NewServeMux()
@@ -548,12 +847,38 @@ func (s *Server) Start(port int) error {
 	// Specific paths like /swagger-specs, /api/ are handled inside s.ServeHTTP.
 	mux.Handle(httpPathRoot, s) // Register the Server itself as the handler for all paths
 
+	tlsConfig, minVersion, err := s.tlsSettings()
+	if err != nil {
+		return nil, err
+	}
 	srv := &http.Server{
 		Addr:      fmt.Sprintf(":%d", port),
 		Handler:   mux,
-		TLSConfig: nil, // TLS is not configured for this server
+		TLSConfig: tlsConfig, // nil unless TLS_CERT_FILE and TLS_KEY_FILE are set
+	}
+	if tlsConfig == nil {
+		s.logger.Infof(logMsgStartingServer, port)
+		return []*listeningServer{{Server: srv, serve: srv.ListenAndServe}}, nil
+	}
+
+	s.logger.Infof(logMsgStartingTLSServer, port, minVersion)
+	servers := []*listeningServer{{Server: srv, serve: func() error {
+		return srv.ListenAndServeTLS("", "") // The certificate comes from TLSConfig.GetCertificate
+	}}}
+	if redirectPort := s.getEnvInt(envVarTLSRedirectPort, 0); redirectPort > 0 {
+		redirect := &http.Server{
+			Addr:              fmt.Sprintf(":%d", redirectPort),
+			Handler:           httpsRedirectHandler(port),
+			ReadHeaderTimeout: redirectReadHeaderTimeout,
+		}
+		s.logger.Infof(logMsgStartingRedirectServer, redirectPort, port)
+		servers = append(servers, &listeningServer{Server: redirect, serve: func() error {
+			// The redirect is a convenience; its failure doesn't stop the server
+			if err := redirect.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				s.logger.Errorf(logMsgRedirectServerFailed, err)
+			}
+			return nil
+		}})
 	}
-
-	s.logger.Infof(logMsgStartingServer, port)
-	return srv.ListenAndServe()
+	return servers, nil
 }
@@ -0,0 +1,120 @@
+package swagger
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"k8s.io/client-go/rest"
+)
+
+// Constants for the Kubernetes API server service proxy
+const (
+	serviceHostSuffix     = "svc"                                     // Host label that marks a cluster-local Service DNS name
+	serviceProxyPathFmt   = "/api/v1/namespaces/%s/services/%s/proxy" // services/{name}:{port}/proxy subresource
+	logMsgServiceProxyURL = "Routing %s through API server service proxy: %s"
+)
+
+// ServiceProxy rewrites in-cluster Service URLs (e.g. http://name.namespace.svc.cluster.local:8080/path)
+// so they are reached through the Kubernetes API server's services/{name}:{port}/proxy subresource.
+// It is used when the server runs outside the cluster with a kubeconfig, where Service DNS names
+// are not resolvable.
+//
+// Requests through the service proxy authenticate to the API server with the kubeconfig
+// credentials. An Authorization header of the request is dropped: it would replace the kubeconfig
+// token, and the API server doesn't pass it on to the Service anyway. Credentials for the Service
+// itself must be sent in another header or in the query.
+type ServiceProxy struct {
+	apiServer *url.URL     // Base URL of the Kubernetes API server
+	client    *http.Client // Client authenticated with the kubeconfig credentials
+}
+
+// NewServiceProxy creates a ServiceProxy that authenticates against the API server described by config.
+func NewServiceProxy(config *rest.Config) (*ServiceProxy, error) {
+	apiServer, err := url.Parse(config.Host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse API server host %q: %w", config.Host, err)
+	}
+	client, err := rest.HTTPClientFor(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create API server client: %w", err)
+	}
+	client.Transport = withoutAuthorization{next: client.Transport}
+	return &ServiceProxy{apiServer: apiServer, client: client}, nil
+}
+
+// Rewrite returns the API server proxy URL for a cluster-local Service URL.
+// The second return value is false if target does not address a Service by its cluster DNS name.
+func (p *ServiceProxy) Rewrite(target *url.URL) (*url.URL, bool) {
+	name, namespace, ok := parseServiceHost(target.Hostname())
+	if !ok {
+		return nil, false
+	}
+
+	// The proxy subresource accepts "[scheme:]name[:port]"; the scheme is only needed for HTTPS backends.
+	service := name
+	if target.Scheme == "https" {
+		service = "https:" + service
+	}
+	if port := target.Port(); port != "" {
+		service += ":" + port
+	}
+
+	proxyPath := fmt.Sprintf(serviceProxyPathFmt, namespace, service)
+	rewritten := *p.apiServer
+	rewritten.Path = strings.TrimSuffix(p.apiServer.Path, "/") + proxyPath + target.Path
+	rewritten.RawPath = strings.TrimSuffix(p.apiServer.EscapedPath(), "/") + proxyPath + target.EscapedPath() // Keeps %2F and the like as sent
+	rewritten.RawQuery = target.RawQuery
+	rewritten.Fragment = ""
+	return &rewritten, true
+}
+
+// withoutAuthorization removes the Authorization header of requests before passing them to next,
+// which sets the kubeconfig credentials.
+type withoutAuthorization struct {
+	next http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t withoutAuthorization) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get(headerAuthorization) != "" {
+		req = req.Clone(req.Context()) // RoundTrippers must not modify the request
+		req.Header.Del(headerAuthorization)
+	}
+	return t.next.RoundTrip(req)
+}
+
+// parseServiceHost extracts the Service name and namespace from a host of the form
+// name.namespace.svc[.cluster-domain].
+func parseServiceHost(host string) (name, namespace string, ok bool) {
+	labels := strings.Split(host, ".")
+	if len(labels) < 3 || labels[2] != serviceHostSuffix || labels[0] == "" || labels[1] == "" {
+		return "", "", false
+	}
+	return labels[0], labels[1], true
+}
+
+// resolveUpstream returns the URL and HTTP client to use for reaching rawURL in the given cluster.
+// When a ServiceProxy is configured for the cluster and rawURL addresses a cluster-local Service,
+// the request is routed through that cluster's API server; otherwise rawURL is used directly
+// with the direct client.
+func (s *Server) resolveUpstream(cluster string, rawURL string, direct *http.Client) (string, *http.Client) {
+	s.serviceProxiesMux.RLock()
+	proxy := s.serviceProxies[cluster]
+	s.serviceProxiesMux.RUnlock()
+
+	if proxy == nil {
+		return rawURL, direct
+	}
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL, direct
+	}
+	rewritten, ok := proxy.Rewrite(target)
+	if !ok {
+		return rawURL, direct
+	}
+	s.logger.Debugf(logMsgServiceProxyURL, rawURL, rewritten.String())
+	return rewritten.String(), proxy.client
+}
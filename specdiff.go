@@ -0,0 +1,501 @@
+package swagger
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Constants for comparing the specs of two APIs or environments
+const (
+	httpPathSpecDiff = "/spec-diff"
+
+	queryParamDiffBase      = "base"      // API key of the spec compared against
+	queryParamDiffTarget    = "target"    // API key of the compared spec; defaults to base
+	queryParamDiffBaseEnv   = "baseEnv"   // Environment of the base API
+	queryParamDiffTargetEnv = "targetEnv" // Environment of the target API
+
+	specChangeAdded   = "added"
+	specChangeRemoved = "removed"
+	specChangeChanged = "changed"
+
+	errMsgDiffBaseRequired = "base query parameter is required"
+	errMsgDiffSpecFailed   = "Failed to load spec of %s: %v"
+)
+
+// diffedSchemaKeywords are the schema keywords compared by value. Properties, items, required
+// and enum are compared item by item instead.
+var diffedSchemaKeywords = []string{
+	"type", "format", "nullable", "x-nullable", "default", "pattern",
+	"minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum", "multipleOf",
+	"minLength", "maxLength", "minItems", "maxItems", "uniqueItems", "minProperties", "maxProperties",
+	"readOnly", "writeOnly", "allOf", "anyOf", "oneOf", "not",
+}
+
+// specChange is one difference between two specs. Location is a dotted path such as
+// "parameters.query.limit.maximum" or "responses.200.content.application/json.schema.name".
+type specChange struct {
+	Location string      `json:"location"`
+	Change   string      `json:"change"` // added, removed or changed
+	From     interface{} `json:"from,omitempty"`
+	To       interface{} `json:"to,omitempty"`
+}
+
+// diffedSpec identifies one side of a comparison.
+type diffedSpec struct {
+	API         string `json:"api"`
+	Environment string `json:"environment,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Version     string `json:"version,omitempty"`
+}
+
+// diffedOperation is an operation present in only one of the specs, or changed between them.
+type diffedOperation struct {
+	Method      string       `json:"method"`
+	Path        string       `json:"path"` // Path template in the target spec, or in the base spec if removed
+	OperationID string       `json:"operationId,omitempty"`
+	Changes     []specChange `json:"changes,omitempty"`
+}
+
+// diffedSchema is a named schema (components.schemas or definitions) changed between the specs.
+type diffedSchema struct {
+	Name    string       `json:"name"`
+	Changes []specChange `json:"changes"`
+}
+
+// specDiff is the comparison of two specs, served at /spec-diff.
+type specDiff struct {
+	Base       diffedSpec `json:"base"`
+	Target     diffedSpec `json:"target"`
+	Identical  bool       `json:"identical"`
+	Operations struct {
+		Added   []diffedOperation `json:"added"`
+		Removed []diffedOperation `json:"removed"`
+		Changed []diffedOperation `json:"changed"`
+	} `json:"operations"`
+	Schemas struct {
+		Added   []string       `json:"added"`
+		Removed []string       `json:"removed"`
+		Changed []diffedSchema `json:"changed"`
+	} `json:"schemas"`
+}
+
+// specOperation is an operation of a spec, keyed for comparison by method and path template
+// with parameter names removed, so that /pets/{id} and /pets/{petId} are the same operation.
+type specOperation struct {
+	method string
+	path   string
+	item   map[string]interface{}
+	op     map[string]interface{}
+}
+
+// specDiffer compares two specs. $refs to named schemas are compared by name; the named schemas
+// themselves are compared once, in the schemas section.
+type specDiffer struct {
+	base, target map[string]interface{}
+}
+
+// serveSpecDiff compares the specs of two registered APIs, or two environments of one API:
+// /spec-diff?base=orders&baseEnv=staging&targetEnv=prod.
+func (s *Server) serveSpecDiff(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	baseKey := query.Get(queryParamDiffBase)
+	if baseKey == "" {
+		s.logAndSendError(w, r, http.StatusBadRequest, errMsgDiffBaseRequired, errMsgDiffBaseRequired)
+		return
+	}
+	targetKey := query.Get(queryParamDiffTarget)
+	if targetKey == "" {
+		targetKey = baseKey
+	}
+
+	base, baseSide, ok := s.diffSide(w, r, baseKey, query.Get(queryParamDiffBaseEnv))
+	if !ok {
+		return
+	}
+	target, targetSide, ok := s.diffSide(w, r, targetKey, query.Get(queryParamDiffTargetEnv))
+	if !ok {
+		return
+	}
+
+	diff := (&specDiffer{base: base, target: target}).diff()
+	diff.Base, diff.Target = baseSide, targetSide
+	s.writeJSON(w, r, http.StatusOK, diff)
+}
+
+// diffSide loads the spec of an API, or of one of its environments. On failure an error response
+// is sent and false is returned.
+func (s *Server) diffSide(w http.ResponseWriter, r *http.Request, key, environment string) (map[string]interface{}, diffedSpec, bool) {
+	s.specsMux.RLock()
+	metadata, exists := s.specs[key]
+	s.specsMux.RUnlock()
+	if !exists {
+		s.logAndSendError(w, r, http.StatusNotFound, fmt.Sprintf("%s: %s", errMsgAPINotFound, key), errMsgAPINotFound)
+		return nil, diffedSpec{}, false
+	}
+	if environment != "" {
+		if key, metadata, exists = s.environmentEntry(key, metadata, environment); !exists {
+			s.logAndSendError(w, r, http.StatusNotFound, fmt.Sprintf("%s: %s", errMsgEnvironmentNotFound, environment), errMsgEnvironmentNotFound)
+			return nil, diffedSpec{}, false
+		}
+	}
+
+	spec, err := s.cachedSpecFor(key)
+	if err != nil {
+		s.logAndSendError(w, r, http.StatusBadGateway, fmt.Sprintf(errMsgDiffSpecFailed, key, err), fmt.Sprintf(errMsgDiffSpecFailed, key, "spec unavailable"))
+		return nil, diffedSpec{}, false
+	}
+	side := diffedSpec{API: key, Environment: metadata.Environment}
+	if isEnvironmentEntry(metadata) {
+		side.API = specKey(APIMetadata{Name: metadata.Parent, Cluster: metadata.Cluster}) // Named as the API it belongs to
+	}
+	info := asObject(spec["info"])
+	side.Title, _ = info["title"].(string)
+	side.Version, _ = info["version"].(string)
+	return spec, side, true
+}
+
+// diff compares the operations and named schemas of the two specs.
+func (d *specDiffer) diff() *specDiff {
+	diff := &specDiff{}
+	diff.Operations.Added, diff.Operations.Removed, diff.Operations.Changed = []diffedOperation{}, []diffedOperation{}, []diffedOperation{}
+	diff.Schemas.Added, diff.Schemas.Removed, diff.Schemas.Changed = []string{}, []string{}, []diffedSchema{}
+
+	baseOperations, targetOperations := specOperations(d.base), specOperations(d.target)
+	for _, key := range sortedOperationKeys(baseOperations, targetOperations) {
+		before, inBase := baseOperations[key]
+		after, inTarget := targetOperations[key]
+		switch {
+		case !inTarget:
+			diff.Operations.Removed = append(diff.Operations.Removed, before.describe(nil))
+		case !inBase:
+			diff.Operations.Added = append(diff.Operations.Added, after.describe(nil))
+		default:
+			if changes := d.operationChanges(before, after); len(changes) > 0 {
+				diff.Operations.Changed = append(diff.Operations.Changed, after.describe(changes))
+			}
+		}
+	}
+
+	baseSchemas, targetSchemas := namedSchemas(d.base), namedSchemas(d.target)
+	for _, name := range sortedKeys(baseSchemas) {
+		if _, ok := targetSchemas[name]; !ok {
+			diff.Schemas.Removed = append(diff.Schemas.Removed, name)
+			continue
+		}
+		if changes := d.schemaChanges(asObject(baseSchemas[name]), asObject(targetSchemas[name]), "", 0); len(changes) > 0 {
+			diff.Schemas.Changed = append(diff.Schemas.Changed, diffedSchema{Name: name, Changes: changes})
+		}
+	}
+	for _, name := range sortedKeys(targetSchemas) {
+		if _, ok := baseSchemas[name]; !ok {
+			diff.Schemas.Added = append(diff.Schemas.Added, name)
+		}
+	}
+
+	diff.Identical = len(diff.Operations.Added)+len(diff.Operations.Removed)+len(diff.Operations.Changed)+
+		len(diff.Schemas.Added)+len(diff.Schemas.Removed)+len(diff.Schemas.Changed) == 0
+	return diff
+}
+
+// specOperations returns the operations of a spec by method and normalized path template.
+func specOperations(spec map[string]interface{}) map[string]specOperation {
+	operations := make(map[string]specOperation)
+	paths := asObject(spec["paths"])
+	for path, item := range paths {
+		pathItem := resolveRef(spec, asObject(item))
+		for _, method := range specMethods {
+			if op, ok := pathItem[method].(map[string]interface{}); ok {
+				key := strings.ToUpper(method) + " " + templateParamPattern.ReplaceAllString(path, "{}")
+				operations[key] = specOperation{method: strings.ToUpper(method), path: path, item: pathItem, op: op}
+			}
+		}
+	}
+	return operations
+}
+
+// sortedOperationKeys returns the keys of both operation maps, sorted by path and then method.
+func sortedOperationKeys(maps ...map[string]specOperation) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, operations := range maps {
+		for key := range operations {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		methodI, pathI, _ := strings.Cut(keys[i], " ")
+		methodJ, pathJ, _ := strings.Cut(keys[j], " ")
+		return pathI < pathJ || pathI == pathJ && methodI < methodJ
+	})
+	return keys
+}
+
+// describe returns the operation as reported in a diff.
+func (o specOperation) describe(changes []specChange) diffedOperation {
+	operationID, _ := o.op["operationId"].(string)
+	return diffedOperation{Method: o.method, Path: o.path, OperationID: operationID, Changes: changes}
+}
+
+// namedSchemas returns the reusable schemas of a spec: components.schemas, or definitions in
+// Swagger 2.0.
+func namedSchemas(spec map[string]interface{}) map[string]interface{} {
+	if schemas := asObject(asObject(spec["components"])["schemas"]); schemas != nil {
+		return schemas
+	}
+	return asObject(spec["definitions"])
+}
+
+// operationChanges compares two versions of an operation: deprecation, parameters, request body
+// and responses.
+func (d *specDiffer) operationChanges(before, after specOperation) []specChange {
+	var changes []specChange
+	if !reflect.DeepEqual(before.op["deprecated"], after.op["deprecated"]) {
+		changes = append(changes, specChange{Location: "deprecated", Change: specChangeChanged, From: before.op["deprecated"], To: after.op["deprecated"]})
+	}
+
+	beforeParams := parametersByLocation(d.base, before, pathParamRenames(before.path, after.path))
+	afterParams := parametersByLocation(d.target, after, nil)
+	for _, key := range sortedKeys(beforeParams) {
+		location := "parameters." + key
+		afterParam, ok := afterParams[key]
+		if !ok {
+			changes = append(changes, specChange{Location: location, Change: specChangeRemoved, From: beforeParams[key]})
+			continue
+		}
+		beforeParam := asObject(beforeParams[key])
+		if !reflect.DeepEqual(beforeParam["required"], asObject(afterParam)["required"]) {
+			changes = append(changes, specChange{Location: location + ".required", Change: specChangeChanged, From: beforeParam["required"], To: asObject(afterParam)["required"]})
+		}
+		changes = append(changes, d.schemaChanges(parameterSchema(beforeParam), parameterSchema(asObject(afterParam)), location, 0)...)
+	}
+	for _, key := range sortedKeys(afterParams) {
+		if _, ok := beforeParams[key]; !ok {
+			changes = append(changes, specChange{Location: "parameters." + key, Change: specChangeAdded, To: afterParams[key]})
+		}
+	}
+
+	beforeBody, afterBody := requestBody(d.base, before), requestBody(d.target, after)
+	switch {
+	case beforeBody == nil && afterBody != nil:
+		changes = append(changes, specChange{Location: "requestBody", Change: specChangeAdded})
+	case beforeBody != nil && afterBody == nil:
+		changes = append(changes, specChange{Location: "requestBody", Change: specChangeRemoved})
+	case beforeBody != nil:
+		if !reflect.DeepEqual(beforeBody["required"], afterBody["required"]) {
+			changes = append(changes, specChange{Location: "requestBody.required", Change: specChangeChanged, From: beforeBody["required"], To: afterBody["required"]})
+		}
+		changes = append(changes, d.contentChanges(beforeBody, afterBody, "requestBody")...)
+	}
+
+	beforeResponses, afterResponses := asObject(before.op["responses"]), asObject(after.op["responses"])
+	for _, status := range sortedKeys(beforeResponses) {
+		location := "responses." + status
+		if _, ok := afterResponses[status]; !ok {
+			changes = append(changes, specChange{Location: location, Change: specChangeRemoved})
+			continue
+		}
+		beforeResponse := resolveRef(d.base, asObject(beforeResponses[status]))
+		afterResponse := resolveRef(d.target, asObject(afterResponses[status]))
+		changes = append(changes, d.contentChanges(beforeResponse, afterResponse, location)...)
+	}
+	for _, status := range sortedKeys(afterResponses) {
+		if _, ok := beforeResponses[status]; !ok {
+			changes = append(changes, specChange{Location: "responses." + status, Change: specChangeAdded})
+		}
+	}
+	return changes
+}
+
+// parametersByLocation returns the parameters of an operation by "<in>.<name>", with path
+// parameters renamed as given. Swagger 2.0 body parameters are compared as the request body
+// instead and left out.
+func parametersByLocation(spec map[string]interface{}, operation specOperation, renames map[string]string) map[string]interface{} {
+	params := make(map[string]interface{})
+	for _, param := range operationParameters(spec, &operationMatch{PathItem: operation.item, Operation: operation.op}) {
+		if param.in == "body" {
+			continue
+		}
+		name := param.name
+		switch param.in {
+		case "header":
+			name = strings.ToLower(name)
+		case "path":
+			if renamed, ok := renames[name]; ok {
+				name = renamed
+			}
+		}
+		params[param.in+"."+name] = param.definition
+	}
+	return params
+}
+
+// requestBody returns the request body of an operation. A Swagger 2.0 body parameter is returned
+// as a request body with a single schema.
+func requestBody(spec map[string]interface{}, operation specOperation) map[string]interface{} {
+	if body := resolveRef(spec, asObject(operation.op["requestBody"])); body != nil {
+		return body
+	}
+	for _, param := range operationParameters(spec, &operationMatch{PathItem: operation.item, Operation: operation.op}) {
+		if param.in == "body" {
+			return map[string]interface{}{"required": param.definition["required"], "schema": param.definition["schema"]}
+		}
+	}
+	return nil
+}
+
+// pathParamRenames maps the path parameter names of one path template to those at the same
+// position in another, such as id to petId for /pets/{id} and /pets/{petId}.
+func pathParamRenames(from, to string) map[string]string {
+	fromNames := templateParamPattern.FindAllStringSubmatch(from, -1)
+	toNames := templateParamPattern.FindAllStringSubmatch(to, -1)
+	renames := make(map[string]string, len(fromNames))
+	for i := range fromNames {
+		if i < len(toNames) {
+			renames[fromNames[i][1]] = toNames[i][1]
+		}
+	}
+	return renames
+}
+
+// parameterSchema returns the schema of a parameter. Swagger 2.0 parameters other than body
+// parameters are schemas themselves.
+func parameterSchema(param map[string]interface{}) map[string]interface{} {
+	if schema := asObject(param["schema"]); schema != nil {
+		return schema
+	}
+	return param
+}
+
+// contentChanges compares the bodies of two request bodies or responses by media type. Swagger 2.0
+// responses have a single schema, and Swagger 2.0 request bodies are body parameters; both are
+// compared under the media type "*/*".
+func (d *specDiffer) contentChanges(before, after map[string]interface{}, location string) []specChange {
+	beforeContent, afterContent := bodySchemas(before), bodySchemas(after)
+	var changes []specChange
+	for _, mediaType := range sortedKeys(beforeContent) {
+		mediaLocation := location + ".content." + mediaType
+		if _, ok := afterContent[mediaType]; !ok {
+			changes = append(changes, specChange{Location: mediaLocation, Change: specChangeRemoved})
+			continue
+		}
+		changes = append(changes, d.schemaChanges(asObject(beforeContent[mediaType]), asObject(afterContent[mediaType]), mediaLocation+".schema", 0)...)
+	}
+	for _, mediaType := range sortedKeys(afterContent) {
+		if _, ok := beforeContent[mediaType]; !ok {
+			changes = append(changes, specChange{Location: location + ".content." + mediaType, Change: specChangeAdded})
+		}
+	}
+	return changes
+}
+
+// bodySchemas returns the schemas of a request body or response by media type.
+func bodySchemas(object map[string]interface{}) map[string]interface{} {
+	schemas := make(map[string]interface{})
+	for mediaType, media := range asObject(object["content"]) {
+		schemas[mediaType] = asObject(asObject(media)["schema"])
+	}
+	if schema, ok := object["schema"].(map[string]interface{}); ok {
+		schemas["*/*"] = schema
+	}
+	return schemas
+}
+
+// schemaChanges compares two schemas. References are compared by the name they point to, so a
+// changed named schema is reported once in the schemas section rather than for every use.
+func (d *specDiffer) schemaChanges(before, after map[string]interface{}, location string, depth int) []specChange {
+	if depth > schemaMaxDepth {
+		return nil
+	}
+	beforeRef, _ := before["$ref"].(string)
+	afterRef, _ := after["$ref"].(string)
+	if beforeRef != "" || afterRef != "" {
+		if refName(beforeRef) != refName(afterRef) {
+			return []specChange{{Location: joinLocation(location, "$ref"), Change: specChangeChanged, From: nilIfEmpty(beforeRef), To: nilIfEmpty(afterRef)}}
+		}
+		return nil
+	}
+
+	var changes []specChange
+	for _, keyword := range diffedSchemaKeywords {
+		beforeValue, afterValue := before[keyword], after[keyword]
+		if reflect.DeepEqual(beforeValue, afterValue) {
+			continue
+		}
+		change := specChange{Location: joinLocation(location, keyword), Change: specChangeChanged, From: beforeValue, To: afterValue}
+		switch {
+		case beforeValue == nil:
+			change.Change = specChangeAdded
+		case afterValue == nil:
+			change.Change = specChangeRemoved
+		}
+		changes = append(changes, change)
+	}
+
+	changes = append(changes, listChanges(before["enum"], after["enum"], joinLocation(location, "enum"))...)
+	changes = append(changes, listChanges(before["required"], after["required"], joinLocation(location, "required"))...)
+
+	if beforeItems, afterItems := asObject(before["items"]), asObject(after["items"]); beforeItems != nil || afterItems != nil {
+		changes = append(changes, d.schemaChanges(beforeItems, afterItems, joinLocation(location, "items"), depth+1)...)
+	}
+	beforeAdditional, beforeIsSchema := before["additionalProperties"].(map[string]interface{})
+	afterAdditional, afterIsSchema := after["additionalProperties"].(map[string]interface{})
+	if beforeIsSchema && afterIsSchema {
+		changes = append(changes, d.schemaChanges(beforeAdditional, afterAdditional, joinLocation(location, "additionalProperties"), depth+1)...)
+	} else if !reflect.DeepEqual(before["additionalProperties"], after["additionalProperties"]) {
+		changes = append(changes, specChange{Location: joinLocation(location, "additionalProperties"), Change: specChangeChanged, From: before["additionalProperties"], To: after["additionalProperties"]})
+	}
+
+	beforeProperties, afterProperties := asObject(before["properties"]), asObject(after["properties"])
+	for _, name := range sortedKeys(beforeProperties) {
+		propertyLocation := joinLocation(location, name)
+		if _, ok := afterProperties[name]; !ok {
+			changes = append(changes, specChange{Location: propertyLocation, Change: specChangeRemoved})
+			continue
+		}
+		changes = append(changes, d.schemaChanges(asObject(beforeProperties[name]), asObject(afterProperties[name]), propertyLocation, depth+1)...)
+	}
+	for _, name := range sortedKeys(afterProperties) {
+		if _, ok := beforeProperties[name]; !ok {
+			changes = append(changes, specChange{Location: joinLocation(location, name), Change: specChangeAdded})
+		}
+	}
+	return changes
+}
+
+// listChanges reports the values added to and removed from a list keyword such as enum or required.
+func listChanges(before, after interface{}, location string) []specChange {
+	beforeList, _ := before.([]interface{})
+	afterList, _ := after.([]interface{})
+	var changes []specChange
+	for _, value := range beforeList {
+		if !containsValue(afterList, value) {
+			changes = append(changes, specChange{Location: location, Change: specChangeRemoved, From: value})
+		}
+	}
+	for _, value := range afterList {
+		if !containsValue(beforeList, value) {
+			changes = append(changes, specChange{Location: location, Change: specChangeAdded, To: value})
+		}
+	}
+	return changes
+}
+
+// refName returns the last segment of a reference, the name of the schema it points to, so that
+// "#/definitions/Pet" and "#/components/schemas/Pet" are the same.
+func refName(ref string) string {
+	return ref[strings.LastIndex(ref, "/")+1:]
+}
+
+// nilIfEmpty returns nil for an empty string, so that it is omitted from a specChange.
+func nilIfEmpty(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
@@ -0,0 +1,116 @@
+package swagger
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Constants for streamed responses (Server-Sent Events and upgraded connections such as WebSockets)
+const (
+	envVarProxyStreamIdleTimeout = "PROXY_STREAM_IDLE_TIMEOUT_SECONDS" // Closes streams without traffic for this long
+
+	defaultProxyStreamIdleTimeout = 5 * time.Minute
+	contentTypeEventStream        = "text/event-stream"
+
+	headerConnection = "Connection"
+	headerUpgrade    = "Upgrade"
+
+	logMsgStreamIdle = "Closing stream to %s after %s without traffic"
+)
+
+// upgradeHeaders are the headers a protocol switch depends on. They pass the header policy
+// whenever an upgrade is requested, so that allowlists don't need to list them.
+var upgradeHeaders = []string{headerConnection, headerUpgrade, "Sec-Websocket-*"}
+
+// isStreamingResponse reports whether a response is a stream rather than a document: a protocol
+// switch (e.g. WebSocket) or Server-Sent Events. Streams are not subject to the response size
+// limit but are closed when idle.
+func isStreamingResponse(resp *http.Response) bool {
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		return true
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get(headerContentType))
+	return mediaType == contentTypeEventStream
+}
+
+// saveUpgradeHeaders returns the upgrade headers of header if it requests or confirms a protocol
+// switch, so that they can be restored after the header policy is applied.
+func saveUpgradeHeaders(header http.Header) http.Header {
+	if header.Get(headerUpgrade) == "" {
+		return nil
+	}
+	saved := make(http.Header)
+	for name, values := range header {
+		if matchHeader(upgradeHeaders, name) {
+			saved[name] = values
+		}
+	}
+	return saved
+}
+
+// restoreHeaders copies saved headers back into header.
+func restoreHeaders(header, saved http.Header) {
+	for name, values := range saved {
+		header[name] = values
+	}
+}
+
+// idleTimeoutBody closes a streamed response body when no data has been read from it (or, for
+// upgraded connections, written to it) for the idle timeout.
+type idleTimeoutBody struct {
+	io.ReadCloser
+	timeout   time.Duration
+	timer     *time.Timer
+	closeOnce sync.Once
+}
+
+// idleTimeoutConn is an idleTimeoutBody of an upgraded connection, which is also written to.
+// httputil.ReverseProxy requires the body of a 101 response to be an io.ReadWriteCloser.
+type idleTimeoutConn struct {
+	*idleTimeoutBody
+	writer io.Writer
+}
+
+// newIdleTimeoutBody wraps a streamed body; onIdle is called when it is closed for being idle.
+func newIdleTimeoutBody(body io.ReadCloser, timeout time.Duration, onIdle func()) io.ReadCloser {
+	b := &idleTimeoutBody{ReadCloser: body, timeout: timeout}
+	b.timer = time.AfterFunc(timeout, func() {
+		onIdle()
+		b.Close()
+	})
+	if conn, ok := body.(io.ReadWriteCloser); ok {
+		return &idleTimeoutConn{idleTimeoutBody: b, writer: conn}
+	}
+	return b
+}
+
+// Read implements io.Reader.
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.timer.Reset(b.timeout)
+	}
+	return n, err
+}
+
+// Close implements io.Closer.
+func (b *idleTimeoutBody) Close() error {
+	var err error
+	b.closeOnce.Do(func() {
+		b.timer.Stop()
+		err = b.ReadCloser.Close()
+	})
+	return err
+}
+
+// Write implements io.Writer.
+func (c *idleTimeoutConn) Write(p []byte) (int, error) {
+	n, err := c.writer.Write(p)
+	if n > 0 {
+		c.timer.Reset(c.timeout)
+	}
+	return n, err
+}
@@ -0,0 +1,114 @@
+package swagger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// Constants for swagger-config group expansion
+const (
+	groupKeySeparator    = ":"       // Separates the parent API name from the group name in child API names
+	defaultGroupName     = "default" // Group name for a swagger-config's single "url" entry
+	logMsgExpandedGroups = "Expanded swagger-config of %s into %d group(s)"
+
+	errMsgFailedToFetchSwaggerConfig = "failed to fetch swagger-config %s: %v"
+	errMsgSwaggerConfigNoGroups      = "swagger-config %s lists no API groups"
+)
+
+// swaggerConfigURL is one entry of a Swagger UI "urls" list.
+type swaggerConfigURL struct {
+	URL  string `json:"url"`
+	Name string `json:"name"`
+}
+
+// swaggerConfig is the subset of a Swagger UI configuration (e.g. springdoc's
+// /v3/api-docs/swagger-config) that lists API documents.
+type swaggerConfig struct {
+	URL  string             `json:"url"`
+	URLs []swaggerConfigURL `json:"urls"`
+}
+
+// UnmarshalJSON accepts both a swagger-config object and a bare Swagger UI "urls" array.
+func (c *swaggerConfig) UnmarshalJSON(data []byte) error {
+	var urls []swaggerConfigURL
+	if err := json.Unmarshal(data, &urls); err == nil {
+		c.URLs = urls
+		return nil
+	}
+	type plain swaggerConfig // Avoid recursing into this method
+	return json.Unmarshal(data, (*plain)(c))
+}
+
+// expandSwaggerConfig fetches the swagger-config of a parent API and returns one child API per
+// listed group. Children inherit the parent's metadata, are named "<parent>:<group>", and record
+// their parent and group so that /swagger-specs can group them under the parent service.
+// Relative group URLs are resolved against the swagger-config URL. On failure the parent is
+// returned with an Error.
+func (s *Server) expandSwaggerConfig(ctx context.Context, parent APIMetadata) []APIMetadata {
+	configURL, err := s.resolveSwaggerConfigURL(parent)
+	if err != nil {
+		parent.Error = fmt.Sprintf(errMsgFailedToFetchSwaggerConfig, parent.SwaggerConfigURL, err)
+		return []APIMetadata{parent}
+	}
+
+	var config swaggerConfig
+	if err := s.fetchJSON(ctx, parent.Cluster, configURL.String(), &config); err != nil {
+		parent.Error = fmt.Sprintf(errMsgFailedToFetchSwaggerConfig, configURL, err)
+		return []APIMetadata{parent}
+	}
+
+	groups := config.URLs
+	if len(groups) == 0 && config.URL != "" {
+		groups = []swaggerConfigURL{{URL: config.URL, Name: defaultGroupName}}
+	}
+	if len(groups) == 0 {
+		parent.Error = fmt.Sprintf(errMsgSwaggerConfigNoGroups, configURL)
+		return []APIMetadata{parent}
+	}
+
+	children := make([]APIMetadata, 0, len(groups))
+	for _, group := range groups {
+		groupURL, err := configURL.Parse(group.URL)
+		if err != nil {
+			s.logger.Warnf("Skipping group %q of %s with invalid URL %q: %v", group.Name, parent.Name, group.URL, err)
+			continue
+		}
+		name := group.Name
+		if name == "" {
+			name = groupURL.Path
+		}
+
+		child := parent
+		child.Name = parent.Name + groupKeySeparator + name
+		child.URL = groupURL.String()
+		child.Parent = parent.Name
+		child.Group = name
+		child.SwaggerConfigURL = ""
+		child.AutoProbe = false
+		children = append(children, child)
+	}
+	s.logger.Debugf(logMsgExpandedGroups, parent.Name, len(children))
+	return children
+}
+
+// resolveSwaggerConfigURL returns the absolute swagger-config URL of an API. A relative
+// SwaggerConfigURL is resolved against the API's URL.
+func (s *Server) resolveSwaggerConfigURL(api APIMetadata) (*url.URL, error) {
+	configURL, err := url.Parse(api.SwaggerConfigURL)
+	if err != nil {
+		return nil, err
+	}
+	if configURL.IsAbs() {
+		return configURL, nil
+	}
+	base, err := url.Parse(api.URL)
+	if err != nil {
+		return nil, err
+	}
+	if !base.IsAbs() {
+		return nil, fmt.Errorf("relative swaggerConfigUrl requires an absolute url")
+	}
+	return base.ResolveReference(configURL), nil
+}
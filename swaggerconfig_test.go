@@ -0,0 +1,59 @@
+package swagger
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestSwaggerConfigUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    swaggerConfig
+		wantErr bool
+	}{
+		{
+			name: "springdoc swagger-config",
+			data: `{"configUrl": "/v3/api-docs/swagger-config", "urls": [{"url": "/v3/api-docs/admin", "name": "admin"}, {"url": "/v3/api-docs/public", "name": "public"}]}`,
+			want: swaggerConfig{URLs: []swaggerConfigURL{{URL: "/v3/api-docs/admin", Name: "admin"}, {URL: "/v3/api-docs/public", Name: "public"}}},
+		},
+		{
+			name: "single url",
+			data: `{"url": "/v3/api-docs"}`,
+			want: swaggerConfig{URL: "/v3/api-docs"},
+		},
+		{
+			name: "bare urls array",
+			data: `[{"url": "/docs/a.json", "name": "a"}, {"url": "/docs/b.json"}]`,
+			want: swaggerConfig{URLs: []swaggerConfigURL{{URL: "/docs/a.json", Name: "a"}, {URL: "/docs/b.json"}}},
+		},
+		{
+			name: "empty object",
+			data: `{}`,
+			want: swaggerConfig{},
+		},
+		{
+			name:    "not a config",
+			data:    `"swagger-config"`,
+			wantErr: true,
+		},
+		{
+			name:    "malformed",
+			data:    `{"urls": [`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got swaggerConfig
+			err := json.Unmarshal([]byte(tt.data), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal(%s) error = %v, want error %v", tt.data, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Unmarshal(%s) = %+v, want %+v", tt.data, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package swagger
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Constants for serving HTTPS
+const (
+	envVarTLSCertFile       = "TLS_CERT_FILE"               // PEM certificate chain; enables HTTPS together with TLS_KEY_FILE
+	envVarTLSKeyFile        = "TLS_KEY_FILE"                // PEM private key
+	envVarTLSMinVersion     = "TLS_MIN_VERSION"             // Lowest accepted TLS version: 1.0, 1.1, 1.2 or 1.3
+	envVarTLSReloadInterval = "TLS_RELOAD_INTERVAL_SECONDS" // How often the files are checked for changes
+	envVarTLSRedirectPort   = "TLS_HTTP_REDIRECT_PORT"      // Plain-HTTP port that redirects to HTTPS; unset for none
+
+	defaultTLSMinVersion      = "1.2"
+	defaultTLSReloadInterval  = 10 // seconds
+	redirectReadHeaderTimeout = 10 * time.Second
+
+	logMsgStartingTLSServer      = "Starting Swagger UI server on port %d (HTTPS, TLS %s or later)"
+	logMsgStartingRedirectServer = "Redirecting plain HTTP on port %d to HTTPS on port %d"
+	logMsgRedirectServerFailed   = "HTTP redirect listener stopped: %v"
+	logMsgCertificateReloaded    = "Reloaded TLS certificate from %s"
+	logMsgCertificateReloadError = "Failed to reload TLS certificate, keeping the previous one: %v"
+	errMsgTLSFilesIncomplete     = "both %s and %s must be set to serve HTTPS"
+	errMsgTLSMinVersionInvalid   = "invalid %s %q: must be 1.0, 1.1, 1.2 or 1.3"
+)
+
+// tlsVersions maps TLS_MIN_VERSION values to TLS versions.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// fileStamp identifies a version of a file by its modification time and size.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// certReloader serves a certificate from files that may be replaced while the server runs, as
+// with a mounted Kubernetes Secret. The files are checked on handshakes at most once per
+// interval, and reloaded when they changed. A certificate that fails to load (for example while
+// only one of the files has been updated) is retried on the next check; until then the previous
+// one is kept.
+type certReloader struct {
+	certFile, keyFile string
+	interval          time.Duration
+	server            *Server
+
+	mux     sync.Mutex
+	cert    *tls.Certificate
+	stamps  [2]fileStamp
+	checked time.Time
+}
+
+// tlsSettings returns the TLS configuration from TLS_CERT_FILE, TLS_KEY_FILE and TLS_MIN_VERSION,
+// or nil if HTTPS is not enabled. The certificate must load at startup.
+func (s *Server) tlsSettings() (*tls.Config, string, error) {
+	certFile, keyFile := os.Getenv(envVarTLSCertFile), os.Getenv(envVarTLSKeyFile)
+	if certFile == "" && keyFile == "" {
+		return nil, "", nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, "", fmt.Errorf(errMsgTLSFilesIncomplete, envVarTLSCertFile, envVarTLSKeyFile)
+	}
+
+	minVersionName := strings.TrimSpace(os.Getenv(envVarTLSMinVersion))
+	if minVersionName == "" {
+		minVersionName = defaultTLSMinVersion
+	}
+	minVersion, ok := tlsVersions[minVersionName]
+	if !ok {
+		return nil, "", fmt.Errorf(errMsgTLSMinVersionInvalid, envVarTLSMinVersion, minVersionName)
+	}
+
+	reloader := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		interval: time.Duration(s.getEnvInt(envVarTLSReloadInterval, defaultTLSReloadInterval)) * time.Second,
+		server:   s,
+	}
+	if err := reloader.reload(); err != nil {
+		return nil, "", err
+	}
+	return &tls.Config{MinVersion: minVersion, GetCertificate: reloader.getCertificate}, minVersionName, nil
+}
+
+// getCertificate implements tls.Config.GetCertificate.
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if time.Since(c.checked) >= c.interval {
+		c.checked = time.Now()
+		if stamps, err := c.fileStamps(); err == nil && stamps != c.stamps {
+			if err := c.load(stamps); err != nil {
+				c.server.logger.Warnf(logMsgCertificateReloadError, err)
+			} else {
+				c.server.logger.Infof(logMsgCertificateReloaded, c.certFile)
+			}
+		}
+	}
+	return c.cert, nil
+}
+
+// reload loads the certificate unconditionally.
+func (c *certReloader) reload() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	stamps, err := c.fileStamps()
+	if err != nil {
+		return err
+	}
+	c.checked = time.Now()
+	return c.load(stamps)
+}
+
+// load reads the certificate and key, and remembers the file versions they were read from.
+func (c *certReloader) load(stamps [2]fileStamp) error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.cert, c.stamps = &cert, stamps
+	return nil
+}
+
+// fileStamps returns the current versions of the certificate and key files. Symbolic links are
+// followed, so Secret updates (which swap a link) are noticed.
+func (c *certReloader) fileStamps() ([2]fileStamp, error) {
+	var stamps [2]fileStamp
+	for i, file := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return stamps, err
+		}
+		stamps[i] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+	}
+	return stamps, nil
+}
+
+// httpsRedirectHandler redirects plain-HTTP requests to the same URL on the HTTPS port.
+func httpsRedirectHandler(tlsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hostname := r.Host
+		if withoutPort, _, err := net.SplitHostPort(hostname); err == nil {
+			hostname = withoutPort
+		}
+		hostname = strings.Trim(hostname, "[]")
+		host := net.JoinHostPort(hostname, strconv.Itoa(tlsPort))
+		if tlsPort == 443 {
+			host = strings.TrimSuffix(host, ":443")
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,376 @@
+package swagger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Constants for validating proxied requests against the target API's spec
+const (
+	envVarProxyValidateRequests = "PROXY_VALIDATE_REQUESTS" // Validate parameters and JSON bodies before forwarding
+
+	logMsgProxyRequestInvalid   = "Proxy request from %s to %s %s of %s rejected with %d violation(s)"
+	logMsgValidationSkipped     = "Not validating proxy request to %s: %v"
+	errMsgProxyRequestInvalid   = "Request does not match operation %s %s of API %s"
+	errMsgBodyRequired          = "request body is required"
+	errMsgBodyInvalidJSON       = "is not valid JSON: %v"
+	errMsgContentTypeNotAllowed = "must be one of %s"
+	errMsgParameterRequired     = "is required"
+)
+
+// requestParameter is a parameter of an operation.
+type requestParameter struct {
+	definition map[string]interface{} // Parameter object, $ref resolved
+	name       string
+	in         string
+}
+
+// shouldValidateRequests reports whether proxied requests to an API are validated:
+// its validateRequests setting if present, otherwise PROXY_VALIDATE_REQUESTS.
+func (s *Server) shouldValidateRequests(key string) bool {
+	s.specsMux.RLock()
+	defer s.specsMux.RUnlock()
+	if override := s.specs[key].ValidateRequests; override != nil {
+		return *override
+	}
+	return s.validateRequests
+}
+
+// validateProxyRequest checks a request to a registered API against the operation of the API's
+// spec it corresponds to. Violations are answered with a 400 JSON body listing them and false is
+// returned, without contacting the backend. Requests that can't be matched to an operation are
+// let through; PROXY_ENFORCE_OPERATIONS decides about those.
+func (s *Server) validateProxyRequest(w http.ResponseWriter, r *http.Request, target *proxyTarget) bool {
+	if !s.shouldValidateRequests(target.apiKey) {
+		return true
+	}
+	spec, err := s.cachedSpecFor(target.apiKey)
+	if err != nil {
+		s.logger.Warnf(logMsgValidationSkipped, target.apiKey, err)
+		return true
+	}
+	match, _ := findOperation(spec, r.Method, target.url)
+	if match == nil {
+		return true
+	}
+
+	validator := &schemaValidator{spec: spec, direction: schemaRequest}
+	violations := validator.validateParameters(r, target, match)
+	bodyViolations, err := s.validateRequestBody(r, validator, match)
+	if err != nil {
+		s.logger.Warnf(logMsgValidationSkipped, target.apiKey, err)
+		return true
+	}
+	violations = append(violations, bodyViolations...)
+	if len(violations) == 0 {
+		return true
+	}
+
+	s.logger.Warnf(logMsgProxyRequestInvalid, clientIP(r), match.Method, match.Path, target.apiKey, len(violations))
+	s.writeJSON(w, r, http.StatusBadRequest, &proxyError{
+		Error:      fmt.Sprintf(errMsgProxyRequestInvalid, match.Method, match.Path, target.apiKey),
+		API:        target.apiKey,
+		Method:     match.Method,
+		Path:       match.Path,
+		Violations: violations,
+	})
+	return false
+}
+
+// operationParameters returns the parameters of an operation: those of its path item, overridden
+// by those of the operation with the same name and location.
+func operationParameters(spec map[string]interface{}, match *operationMatch) []requestParameter {
+	var params []requestParameter
+	index := make(map[string]int)
+	for _, source := range []interface{}{match.PathItem["parameters"], match.Operation["parameters"]} {
+		list, _ := source.([]interface{})
+		for _, item := range list {
+			definition := resolveRef(spec, asObject(item))
+			name, _ := definition["name"].(string)
+			in, _ := definition["in"].(string)
+			if name == "" || in == "" {
+				continue
+			}
+			param := requestParameter{definition: definition, name: name, in: in}
+			key := in + "\x00" + name
+			if in == "header" {
+				key = in + "\x00" + strings.ToLower(name)
+			}
+			if i, ok := index[key]; ok {
+				params[i] = param
+				continue
+			}
+			index[key] = len(params)
+			params = append(params, param)
+		}
+	}
+	return params
+}
+
+// validateParameters checks the path, query, header and cookie parameters of a request. Query
+// parameters are read from the target URL: the portal's own query holds proxyUrl and cluster.
+func (v *schemaValidator) validateParameters(r *http.Request, target *proxyTarget, match *operationMatch) []schemaViolation {
+	var violations []schemaViolation
+	query := target.url.Query()
+	for _, param := range operationParameters(v.spec, match) {
+		var raw []string
+		switch param.in {
+		case "path":
+			if value, ok := match.PathParams[param.name]; ok {
+				raw = []string{value}
+			}
+		case "query":
+			raw = query[param.name]
+		case "header":
+			raw = r.Header.Values(param.name)
+		case "cookie":
+			if cookie, err := r.Cookie(param.name); err == nil {
+				raw = []string{cookie.Value}
+			}
+		default:
+			continue // Body and form parameters are checked with the body
+		}
+
+		location := param.in + "." + param.name
+		if len(raw) == 0 {
+			if required, _ := param.definition["required"].(bool); required {
+				violations = append(violations, schemaViolation{Location: location, Message: errMsgParameterRequired})
+			}
+			continue
+		}
+		if allowEmpty, _ := param.definition["allowEmptyValue"].(bool); allowEmpty && len(raw) == 1 && raw[0] == "" {
+			continue
+		}
+
+		// OpenAPI 3 parameters carry a schema; Swagger 2.0 parameters are schemas themselves
+		schema, ok := param.definition["schema"].(map[string]interface{})
+		if !ok {
+			if _, isSpec3 := v.spec["openapi"]; isSpec3 {
+				continue // Parameters described by "content" are not checked
+			}
+			schema = param.definition
+		}
+		schema = resolveRef(v.spec, schema)
+		violations = append(violations, v.validate(schema, v.parameterValue(param, schema, raw), location)...)
+	}
+	return violations
+}
+
+// parameterValue converts the raw values of a parameter to the JSON value its schema describes,
+// splitting arrays according to the parameter's style (OpenAPI 3) or collectionFormat (Swagger
+// 2.0). Values that don't convert are kept as strings, so that the schema reports them.
+func (v *schemaValidator) parameterValue(param requestParameter, schema map[string]interface{}, raw []string) interface{} {
+	if !containsString(schemaTypes(schema), "array") {
+		return scalarParameterValue(schemaTypes(schema), raw[0])
+	}
+
+	delimiter, explode := ",", false
+	if _, isSpec3 := v.spec["openapi"]; isSpec3 {
+		style, _ := param.definition["style"].(string)
+		if style == "" && (param.in == "query" || param.in == "cookie") {
+			style = "form"
+		}
+		explode = style == "form"
+		if value, ok := param.definition["explode"].(bool); ok {
+			explode = value
+		}
+		switch style {
+		case "spaceDelimited":
+			delimiter = " "
+		case "pipeDelimited":
+			delimiter = "|"
+		}
+	} else {
+		switch format, _ := param.definition["collectionFormat"].(string); format {
+		case "multi":
+			explode = true
+		case "ssv":
+			delimiter = " "
+		case "tsv":
+			delimiter = "\t"
+		case "pipes":
+			delimiter = "|"
+		}
+	}
+
+	values := raw
+	if !explode {
+		values = strings.Split(raw[0], delimiter)
+	}
+	itemTypes := schemaTypes(resolveRef(v.spec, asObject(schema["items"])))
+	items := make([]interface{}, len(values))
+	for i, value := range values {
+		items[i] = scalarParameterValue(itemTypes, value)
+	}
+	return items
+}
+
+// scalarParameterValue converts a raw parameter value to a number or boolean if its schema
+// types ask for one.
+func scalarParameterValue(types []string, raw string) interface{} {
+	for _, t := range types {
+		switch t {
+		case "integer", "number":
+			if number, err := strconv.ParseFloat(raw, 64); err == nil {
+				return number
+			}
+		case "boolean":
+			if boolean, err := strconv.ParseBool(raw); err == nil {
+				return boolean
+			}
+		}
+	}
+	return raw
+}
+
+// validateRequestBody checks the body of a request against the operation's request body
+// (OpenAPI 3) or body parameter (Swagger 2.0). Only JSON bodies are checked against their schema.
+// The body is buffered and replaced, so it can still be forwarded. An error means the body
+// couldn't be read and validation was abandoned.
+func (s *Server) validateRequestBody(r *http.Request, v *schemaValidator, match *operationMatch) ([]schemaViolation, error) {
+	var required bool
+	var schema map[string]interface{}
+	if requestBody := resolveRef(v.spec, asObject(match.Operation["requestBody"])); requestBody != nil {
+		required, _ = requestBody["required"].(bool)
+		content := asObject(requestBody["content"])
+		if len(content) > 0 && hasBody(r) {
+			mediaType, _, _ := mime.ParseMediaType(r.Header.Get(headerContentType))
+			media, ok := selectMediaType(content, mediaType)
+			if !ok {
+				return []schemaViolation{{
+					Location: "header." + headerContentType,
+					Message:  fmt.Sprintf(errMsgContentTypeNotAllowed, strings.Join(sortedKeys(content), ", ")),
+				}}, nil
+			}
+			if isJSONMediaType(mediaType) {
+				schema = asObject(asObject(media)["schema"])
+			}
+		}
+	} else {
+		for _, param := range operationParameters(v.spec, match) {
+			if param.in == "body" {
+				required, _ = param.definition["required"].(bool)
+				schema = asObject(param.definition["schema"])
+			}
+		}
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get(headerContentType))
+		if mediaType != "" && !isJSONMediaType(mediaType) {
+			schema = nil
+		}
+	}
+	if !required && schema == nil {
+		return nil, nil
+	}
+
+	body, err := s.bufferRequestBody(r)
+	if err != nil || body == nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		if required {
+			return []schemaViolation{{Location: "body", Message: errMsgBodyRequired}}, nil
+		}
+		return nil, nil
+	}
+	if schema == nil {
+		return nil, nil
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return []schemaViolation{{Location: "body", Message: fmt.Sprintf(errMsgBodyInvalidJSON, err)}}, nil
+	}
+	return v.validate(schema, value, "body"), nil
+}
+
+// bufferRequestBody reads the body of r into memory and replaces it with the buffered copy. It
+// returns nil if the body exceeds PROXY_MAX_REQUEST_BYTES; the body is then left for the size
+// limit of the proxy to reject.
+func (s *Server) bufferRequestBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return []byte{}, nil
+	}
+	limit := s.limits.maxRequestBytes
+	if limit > 0 && r.ContentLength > limit {
+		return nil, nil
+	}
+	reader := io.Reader(r.Body)
+	if limit > 0 {
+		reader = io.LimitReader(r.Body, limit+1)
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && int64(len(body)) > limit {
+		r.Body = readCloser{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		return nil, nil
+	}
+	r.Body = readCloser{bytes.NewReader(body), r.Body}
+	return body, nil
+}
+
+// readCloser reads from a replacement reader and closes the original body.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// hasBody reports whether a request announces a body.
+func hasBody(r *http.Request) bool {
+	return r.Body != nil && r.Body != http.NoBody && r.ContentLength != 0
+}
+
+// selectMediaType returns the entry of an OpenAPI 3 content map that applies to a media type:
+// an exact match, then a "type/*" range, then "*/*". A missing Content-Type matches a lone entry.
+func selectMediaType(content map[string]interface{}, mediaType string) (interface{}, bool) {
+	if mediaType == "" && len(content) == 1 {
+		for _, media := range content {
+			return media, true
+		}
+	}
+	for key, media := range content {
+		if parsed, _, err := mime.ParseMediaType(key); err == nil && strings.EqualFold(parsed, mediaType) {
+			return media, true
+		}
+	}
+	if slash := strings.Index(mediaType, "/"); slash > 0 {
+		if media, ok := content[mediaType[:slash]+"/*"]; ok {
+			return media, true
+		}
+	}
+	media, ok := content["*/*"]
+	return media, ok
+}
+
+// isJSONMediaType reports whether a media type is JSON, including structured "+json" types.
+func isJSONMediaType(mediaType string) bool {
+	return mediaType == contentTypeJSON || strings.HasSuffix(mediaType, "+json")
+}
+
+// containsString reports whether list contains value.
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedKeys returns the keys of a JSON object in sorted order.
+func sortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}